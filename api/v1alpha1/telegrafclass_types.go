@@ -0,0 +1,112 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionTypeValid indicates whether the class configuration is valid
+	// telegraf configuration.
+	ConditionTypeValid = "Valid"
+)
+
+// TelegrafClassSpec defines the desired state of a telegraf class.
+type TelegrafClassSpec struct {
+	// Config is the telegraf TOML configuration of the class. Usually contains
+	// agent, outputs and global_tags configuration.
+	// +kubebuilder:validation:MinLength=1
+	Config string `json:"config"`
+}
+
+// TelegrafClassStatus defines the observed state of a telegraf class.
+type TelegrafClassStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Pods is the number of pods with a telegraf configuration rendered from this class.
+	// +optional
+	Pods int32 `json:"pods"`
+
+	// Conditions describe the current state of the class.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=tc
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Pods",type=integer,JSONPath=`.status.pods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TelegrafClass is a cluster-wide telegraf class that can be referenced by
+// pods in any namespace.
+type TelegrafClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TelegrafClassSpec   `json:"spec,omitempty"`
+	Status TelegrafClassStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TelegrafClassList contains a list of TelegrafClass
+type TelegrafClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TelegrafClass `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=tnc
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Pods",type=integer,JSONPath=`.status.pods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TelegrafNamespaceClass is a telegraf class that can only be referenced by
+// pods in its own namespace. It takes precedence over a TelegrafClass with
+// the same name.
+type TelegrafNamespaceClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TelegrafClassSpec   `json:"spec,omitempty"`
+	Status TelegrafClassStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TelegrafNamespaceClassList contains a list of TelegrafNamespaceClass
+type TelegrafNamespaceClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TelegrafNamespaceClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(
+		&TelegrafClass{}, &TelegrafClassList{},
+		&TelegrafNamespaceClass{}, &TelegrafNamespaceClassList{},
+	)
+}
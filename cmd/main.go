@@ -17,11 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	goruntime "runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -29,6 +33,7 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
@@ -44,12 +49,15 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	telegrafv1alpha1 "github.com/jmickey/telegraf-sidecar-operator/api/v1alpha1"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classpolicy"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/config"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/controller"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/injectorwebhook"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/version"
 	//+kubebuilder:scaffold:imports
 )
@@ -66,10 +74,15 @@ const (
 	defaultTelegrafRequestsMemory   = "100Mi"
 	defaultTelegrafLimitsCPU        = ""
 	defaultTelegrafLimitsMemory     = "300Mi"
+
+	classesSourceDirectory = "directory"
+	classesSourceCRD       = "crd"
+	classesSourceAPI       = "api"
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(telegrafv1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 }
@@ -81,8 +94,17 @@ func main() {
 	var secureMetrics bool
 	var enableHTTP2 bool
 
+	var telegrafClassesSource string
 	var telegrafClassesDirectory string
+	var telegrafClassesReload bool
+	var telegrafClassesNamespace string
+	var telegrafClassesSelector string
 	var telegrafDefaultClass string
+	var telegrafMissingClassPolicy string
+	var telegrafClassPolicyFile string
+	var telegrafClassesEnvVars string
+	var telegrafClassesPlugins string
+	var telegrafClassesAgeKeyFile string
 	var telegrafEnableIntervalPlugin bool
 	var telegrafSecretNamePrefix string
 	var telegrafImage string
@@ -91,6 +113,10 @@ func main() {
 	var telegrafLimitsCPU string
 	var telegrafLimitsMemory string
 	var telegrafWatchConfig string
+	var configUpdateRate float64
+	var configUpdateBurst int
+	var orphanedSecretSweepInterval time.Duration
+	var orphanedSecretGracePeriod time.Duration
 	var telegrafSecurityRunAsUser config.OptionalInt64
 	var telegrafSecurityRunAsGroup config.OptionalInt64
 	var telegrafSecurityRunAsNonRoot config.OptionalBool
@@ -125,10 +151,35 @@ func main() {
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers.")
 	featuregate.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&telegrafClassesSource, "telegraf-classes-source", classesSourceDirectory,
+		"Where telegraf classes are loaded from. Valid values: 'directory' to read class files from "+
+			"--telegraf-classes-directory, 'crd' to use TelegrafClass and TelegrafNamespaceClass resources, "+
+			"'api' to watch the Secrets and ConfigMaps matching --telegraf-classes-selector.")
 	flag.StringVar(&telegrafClassesDirectory, "telegraf-classes-directory", "/etc/config/classes",
 		"Path to the directory containing telegraf class files.")
+	flag.BoolVar(&telegrafClassesReload, "telegraf-classes-reload", true,
+		"Watch the telegraf classes directory and reload the class data when it changes.")
+	flag.StringVar(&telegrafClassesNamespace, "telegraf-classes-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the telegraf class Secrets and ConfigMaps when using the 'api' classes source. "+
+			"Default: the POD_NAMESPACE environment variable")
+	flag.StringVar(&telegrafClassesSelector, "telegraf-classes-selector", metadata.TelegrafClassesLabel+"=true",
+		"Label selector of the telegraf class Secrets and ConfigMaps when using the 'api' classes source.")
 	flag.StringVar(&telegrafDefaultClass, "telegraf-default-class", "default",
 		"Default telegraf class to use.")
+	flag.StringVar(&telegrafMissingClassPolicy, "telegraf-missing-class-policy", string(controller.MissingClassPolicyFail),
+		"How the configuration of a pod requesting a class that doesn't exist is rendered. Valid values: "+
+			"'fail', 'default' to use the default class, 'minimal' to write metrics to stdout. Default: fail")
+	flag.StringVar(&telegrafClassesEnvVars, "telegraf-classes-env-vars", "",
+		"Comma-separated list of environment variables classes may reference, in addition to the ones every "+
+			"sidecar has. Requires the telegraf.classvalidation feature gate.")
+	flag.StringVar(&telegrafClassesPlugins, "telegraf-classes-plugins", "",
+		"Comma-separated list of plugins classes may use, in addition to the ones built into telegraf, "+
+			"e.g. 'inputs.custom'. Requires the telegraf.classvalidation feature gate.")
+	flag.StringVar(&telegrafClassesAgeKeyFile, "telegraf-classes-age-key-file", "",
+		"Path to a file containing the age keys used to decrypt age and SOPS encrypted telegraf classes. "+
+			"Default: disabled")
+	flag.StringVar(&telegrafClassPolicyFile, "telegraf-class-policy-file", "",
+		"Path to a YAML file restricting which namespaces can use a telegraf class. Default: disabled")
 	flag.BoolVar(&telegrafEnableIntervalPlugin, "telegraf-enable-internal-plugin", false,
 		"Enable the telegraf internal plugin in for all sidecar containers. "+
 			"If disabled, can be overwritten using pod annotation.")
@@ -146,6 +197,15 @@ func main() {
 		"Set the telegraf configuration secret name prefix, defaults to 'telegraf-config'")
 	flag.StringVar(&telegrafWatchConfig, "telegraf-watch-config", "",
 		"Enable telegraf --watch-config flag. Valid values: 'inotify', 'poll'. Default: disabled")
+	flag.Float64Var(&configUpdateRate, "config-update-rate", 10,
+		"Maximum number of telegraf config secrets per second that are re-rendered after a class has changed. "+
+			"Set to 0 to disable the limit.")
+	flag.IntVar(&configUpdateBurst, "config-update-burst", 10,
+		"Maximum burst of telegraf config secrets that are re-rendered at once after a class has changed.")
+	flag.DurationVar(&orphanedSecretSweepInterval, "orphaned-secret-sweep-interval", time.Minute,
+		"Interval at which telegraf config secrets without a live owning pod are looked for. Zero disables the sweep.")
+	flag.DurationVar(&orphanedSecretGracePeriod, "orphaned-secret-grace-period", 5*time.Minute,
+		"How long a telegraf config secret has to be without a live owning pod before it is deleted.")
 	flag.Var(&telegrafSecurityRunAsUser, "telegraf-security-run-as-user",
 		"User ID for telegraf sidecar containers")
 	flag.Var(&telegrafSecurityRunAsGroup, "telegraf-security-run-as-group",
@@ -214,9 +274,24 @@ func main() {
 		os.Exit(1)
 	}
 
-	classDataHandler, err := classdata.NewDirectoryHandler(telegrafClassesDirectory)
-	if err != nil {
-		setupLog.Error(err, "failed to initialize class data handler")
+	if err := validateClassesSource(telegrafClassesSource); err != nil {
+		setupLog.Error(err, "failed to validate telegraf classes source flag value")
+		os.Exit(1)
+	}
+
+	if err := validateMissingClassPolicy(telegrafMissingClassPolicy); err != nil {
+		setupLog.Error(err, "failed to validate telegraf missing class policy flag value")
+		os.Exit(1)
+	}
+
+	var classPolicy *classpolicy.Policy
+	if telegrafClassPolicyFile != "" {
+		policy, err := classpolicy.Load(telegrafClassPolicyFile)
+		if err != nil {
+			setupLog.Error(err, "failed to load telegraf class policy")
+			os.Exit(1)
+		}
+		classPolicy = policy
 	}
 
 	webhookServer := webhook.NewServer(webhook.Options{
@@ -273,6 +348,84 @@ func main() {
 		os.Exit(1)
 	}
 
+	classDataOptions := []classdata.Option{
+		classdata.WithEnvVars(splitList(telegrafClassesEnvVars)...),
+		classdata.WithPlugins(splitList(telegrafClassesPlugins)...),
+	}
+	if telegrafClassesAgeKeyFile != "" {
+		identities, err := classdata.LoadAgeIdentities(telegrafClassesAgeKeyFile)
+		if err != nil {
+			setupLog.Error(err, "failed to load telegraf classes age keys")
+			os.Exit(1)
+		}
+		classDataOptions = append(classDataOptions, classdata.WithAgeIdentities(identities...))
+	}
+
+	var classDataHandler classdata.Handler
+	switch telegrafClassesSource {
+	case classesSourceDirectory:
+		handler, err := classdata.NewDirectoryHandler(telegrafClassesDirectory, classDataOptions...)
+		if err != nil {
+			setupLog.Error(err, "failed to initialize class data handler")
+			os.Exit(1)
+		}
+		if telegrafClassesReload {
+			if err := mgr.Add(handler); err != nil {
+				setupLog.Error(err, "unable to set up class directory watcher")
+				os.Exit(1)
+			}
+		}
+		classDataHandler = handler
+	case classesSourceCRD:
+		handler := classdata.NewResourceHandler(mgr.GetCache(), classDataOptions...)
+		if err := mgr.Add(handler); err != nil {
+			setupLog.Error(err, "unable to set up class resource watcher")
+			os.Exit(1)
+		}
+		if err := (&controller.TelegrafClassReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "TelegrafClass")
+			os.Exit(1)
+		}
+		classDataHandler = handler
+	case classesSourceAPI:
+		selector, err := labels.Parse(telegrafClassesSelector)
+		if err != nil {
+			setupLog.Error(err, "failed to parse telegraf classes selector", "selector", telegrafClassesSelector)
+			os.Exit(1)
+		}
+		if telegrafClassesNamespace == "" {
+			setupLog.Error(fmt.Errorf("--telegraf-classes-namespace is required"),
+				"failed to initialize class data handler")
+			os.Exit(1)
+		}
+
+		handler, err := classdata.NewAPIHandler(mgr.GetConfig(), mgr.GetScheme(), telegrafClassesNamespace,
+			selector, classDataOptions...)
+		if err != nil {
+			setupLog.Error(err, "failed to initialize class data handler")
+			os.Exit(1)
+		}
+		if err := mgr.Add(handler); err != nil {
+			setupLog.Error(err, "unable to set up class object watcher")
+			os.Exit(1)
+		}
+		classDataHandler = handler
+	}
+
+	var monitorSource *monitors.Source
+	if featuregate.PodMonitors.IsEnabled() {
+		if monitorSource, err = monitors.NewSource(context.Background(), mgr); err != nil {
+			setupLog.Error(err, "failed to initialize monitor source")
+			os.Exit(1)
+		}
+		if len(monitorSource.Kinds()) == 0 {
+			setupLog.Info("PodMonitor and ServiceMonitor CRDs are not installed, monitors are ignored")
+		}
+	}
+
 	if err = (&controller.PodReconciler{
 		Client:               mgr.GetClient(),
 		Scheme:               mgr.GetScheme(),
@@ -280,11 +433,27 @@ func main() {
 		ClassDataHandler:     classDataHandler,
 		DefaultClass:         telegrafDefaultClass,
 		EnableInternalPlugin: telegrafEnableIntervalPlugin,
+		ConfigUpdateRate:     rate.Limit(configUpdateRate),
+		ConfigUpdateBurst:    configUpdateBurst,
+		ClassPolicy:          classPolicy,
+		MissingClassPolicy:   controller.MissingClassPolicy(telegrafMissingClassPolicy),
+		Monitors:             monitorSource,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
 	}
 
+	if orphanedSecretSweepInterval > 0 {
+		if err = (&controller.SecretSweeper{
+			Client:      mgr.GetClient(),
+			Interval:    orphanedSecretSweepInterval,
+			GracePeriod: orphanedSecretGracePeriod,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create orphaned secret sweeper")
+			os.Exit(1)
+		}
+	}
+
 	admission := &injectorwebhook.SidecarInjector{
 		SecretNamePrefix: telegrafSecretNamePrefix,
 		TelegrafImage:    telegrafImage,
@@ -301,6 +470,12 @@ func main() {
 		SecurityAllowPrivilegeEscalation: &telegrafSecurityAllowPrivEsc,
 		SecurityCapabilitiesAdd:          telegrafSecurityCapAdd,
 		SecurityCapabilitiesDrop:         telegrafSecurityCapDrop,
+
+		ClassPolicy:      classPolicy,
+		Client:           mgr.GetClient(),
+		DefaultClass:     telegrafDefaultClass,
+		ClassDataHandler: classDataHandler,
+		Monitors:         monitorSource,
 	}
 
 	if err = admission.SetupWithManager(mgr); err != nil {
@@ -317,6 +492,17 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	// The webhook isn't sent pods until the classes the class policy is
+	// checked against have been loaded.
+	if err := mgr.AddReadyzCheck("classdata", func(*http.Request) error {
+		if !classDataHandler.HasSynced() {
+			return errors.New("telegraf classes haven't been loaded yet")
+		}
+		return nil
+	}); err != nil {
+		setupLog.Error(err, "unable to set up class data ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting Telegraf Sidecar Operator",
 		"manager-version", version.Version, "git-commit", version.GitCommit, "go-version", goruntime.Version())
@@ -356,3 +542,34 @@ func validateWatchConfig(watchConfig string) error {
 
 	return fmt.Errorf("invalid watch-config value '%s', valid values are: %v", watchConfig, validValues)
 }
+
+func validateClassesSource(source string) error {
+	switch source {
+	case classesSourceDirectory, classesSourceCRD, classesSourceAPI:
+		return nil
+	}
+
+	return fmt.Errorf("invalid classes source value '%s', valid values are: %v",
+		source, []string{classesSourceDirectory, classesSourceCRD, classesSourceAPI})
+}
+
+func validateMissingClassPolicy(policy string) error {
+	if slices.Contains(controller.MissingClassPolicies, controller.MissingClassPolicy(policy)) {
+		return nil
+	}
+
+	return fmt.Errorf("invalid missing class policy value '%s', valid values are: %v",
+		policy, controller.MissingClassPolicies)
+}
+
+// splitList splits a comma-separated flag value, ignoring empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// APIHandler serves class data from the Secrets and ConfigMaps matching a label
+// selector in a single namespace. Each key of the objects is a class, named the
+// same way as the files read by DirectoryHandler.
+//
+// The objects are read through a dedicated cache, as the manager cache only
+// holds the secrets managed by the operator.
+type APIHandler struct {
+	subscribers
+
+	cache  cache.Cache
+	reader client.Reader
+	data   map[string][]byte
+	mu     sync.RWMutex
+	// watching is set once the initial list of objects has been delivered,
+	// and synced once the class data has been built from it.
+	watching atomic.Bool
+	synced   atomic.Bool
+	loader   *loader
+}
+
+func NewAPIHandler(config *rest.Config, scheme *runtime.Scheme, namespace string, selector labels.Selector,
+	opts ...Option) (*APIHandler, error) {
+	c, err := cache.New(config, cache.Options{
+		Scheme:               scheme,
+		DefaultNamespaces:    map[string]cache.Config{namespace: {}},
+		DefaultLabelSelector: selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create class object cache for namespace: %s, error: %w", namespace, err)
+	}
+
+	return &APIHandler{
+		cache:  c,
+		reader: c,
+		data:   make(map[string][]byte),
+		loader: newLoader(opts...),
+	}, nil
+}
+
+// HasSynced returns whether the class data has been built from the initial
+// list of class objects.
+func (h *APIHandler) HasSynced() bool {
+	return h.synced.Load()
+}
+
+func (h *APIHandler) GetDataForClass(name string) ([]byte, bool) {
+	h.mu.RLock()
+	data, ok := h.data[name]
+	h.mu.RUnlock()
+
+	return data, ok
+}
+
+// Update rebuilds the class data from the cached objects. The previous class
+// data is kept if no classes could be found, and the previous version of every
+// class failing validation is kept, while the other classes are updated.
+func (h *APIHandler) Update() error {
+	ctx := context.Background()
+	files := make(map[string][]byte)
+	failed := make(map[string]error)
+	add := func(kind, name string, data map[string][]byte) {
+		for _, key := range slices.Sorted(maps.Keys(data)) {
+			if _, ok := files[key]; ok {
+				class, _ := className(key)
+				failed[class] = fmt.Errorf("class: %s is defined more than once, last defined by %s: %s",
+					class, kind, name)
+				continue
+			}
+			files[key] = data[key]
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := h.reader.List(ctx, secrets); err != nil {
+		return fmt.Errorf("failed to list class secrets: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		add("Secret", secret.Name, secret.Data)
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := h.reader.List(ctx, configMaps); err != nil {
+		return fmt.Errorf("failed to list class config maps: %w", err)
+	}
+	for _, configMap := range configMaps.Items {
+		data := maps.Clone(configMap.BinaryData)
+		if data == nil {
+			data = make(map[string][]byte, len(configMap.Data))
+		}
+		for key, value := range configMap.Data {
+			data[key] = []byte(value)
+		}
+		add("ConfigMap", configMap.Name, data)
+	}
+
+	// Deleting or relabelling the objects by mistake shouldn't remove every class.
+	if len(files) == 0 {
+		return fmt.Errorf("failed to update class data, no data could be found, keeping previous")
+	}
+
+	data, convertErrs := h.loader.convertClassData(files)
+	maps.Copy(failed, convertErrs)
+	for name := range failed {
+		delete(data, name)
+	}
+
+	h.mu.RLock()
+	previous := h.data
+	h.mu.RUnlock()
+
+	data, errs := h.loader.prepare(data, failed, previous)
+
+	h.mu.Lock()
+	changed := changedClasses(h.data, data)
+	h.data = data
+	h.mu.Unlock()
+
+	h.notify(changed)
+
+	if errs != nil {
+		return fmt.Errorf("failed to validate updated class data, keeping previous version of invalid classes: %w", errs)
+	}
+
+	return nil
+}
+
+// Start runs the class object cache and updates the class data whenever one of
+// the objects changes, until ctx is cancelled. It implements manager.Runnable.
+func (h *APIHandler) Start(ctx context.Context) error {
+	log := logf.Log.WithName("classdata")
+
+	reload := func() {
+		if err := h.Update(); err != nil {
+			log.Error(err, "failed to update class data from class objects")
+		}
+	}
+	// The class data is only built once the initial list of objects has been
+	// delivered, rather than once for each of them. Update reads the objects
+	// from the cache, so the ones delivered before then aren't lost.
+	onChange := func() {
+		if h.watching.Load() {
+			reload()
+		}
+	}
+	eventHandler := toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { onChange() },
+		UpdateFunc: func(any, any) { onChange() },
+		DeleteFunc: func(any) { onChange() },
+	}
+
+	var registrations []toolscache.InformerSynced
+	for _, obj := range []client.Object{&corev1.Secret{}, &corev1.ConfigMap{}} {
+		informer, err := h.cache.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("failed to get informer for %T: %w", obj, err)
+		}
+		registration, err := informer.AddEventHandler(eventHandler)
+		if err != nil {
+			return fmt.Errorf("failed to add event handler for %T: %w", obj, err)
+		}
+		registrations = append(registrations, registration.HasSynced)
+	}
+
+	// Waiting for the initial list of objects stops if the cache fails to start.
+	cacheCtx, cacheStopped := context.WithCancel(ctx)
+	cacheErr := make(chan error, 1)
+	go func() {
+		defer cacheStopped()
+		cacheErr <- h.cache.Start(ctx)
+	}()
+
+	if toolscache.WaitForCacheSync(cacheCtx.Done(), registrations...) {
+		h.watching.Store(true)
+		reload()
+		h.synced.Store(true)
+		log.Info("loaded class data from class objects")
+	}
+
+	if err := <-cacheErr; err != nil {
+		return fmt.Errorf("failed to run class object cache: %w", err)
+	}
+
+	return nil
+}
+
+// NeedLeaderElection ensures the class data is kept up to date on every
+// replica, so that a replica taking over leadership isn't serving stale data.
+func (h *APIHandler) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAPIHandler_Update(t *testing.T) {
+	ctx := context.Background()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "classes", Namespace: "telegraf"},
+		Data:       map[string][]byte{"a": []byte(testClassA)},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "classes", Namespace: "telegraf"},
+		Data:       map[string]string{"b.yaml": "outputs:\n  file:\n    - files: [\"stderr\"]\n"},
+	}
+	c := fake.NewClientBuilder().WithObjects(secret, configMap).Build()
+
+	h := &APIHandler{
+		reader: c,
+		data:   make(map[string][]byte),
+		loader: newLoader(),
+	}
+
+	var notified [][]string
+	h.Subscribe(func(classes []string) {
+		notified = append(notified, classes)
+	})
+
+	update := func(t *testing.T, obj client.Object) {
+		t.Helper()
+		if err := c.Update(ctx, obj); err != nil {
+			t.Fatalf("failed to update %T: %v", obj, err)
+		}
+	}
+
+	t.Run("classes are read from secrets and config maps", func(t *testing.T) {
+		if err := h.Update(); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if data, _ := h.GetDataForClass("a"); string(data) != testClassA {
+			t.Errorf("class a = %q, want %q", data, testClassA)
+		}
+		if _, ok := h.GetDataForClass("b"); !ok {
+			t.Errorf("expected class b to exist")
+		}
+		if len(notified) != 1 || !slices.Equal(notified[0], []string{"a", "b"}) {
+			t.Errorf("notified = %v, want [[a b]]", notified)
+		}
+	})
+
+	t.Run("bad update keeps the last good class", func(t *testing.T) {
+		notified = nil
+		secret.Data = map[string][]byte{"a": []byte("[[outputs.file"), "c": []byte(testClassB)}
+		update(t, secret)
+
+		if err := h.Update(); err == nil {
+			t.Fatalf("expected Update() to fail with invalid class data")
+		}
+		if data, _ := h.GetDataForClass("a"); string(data) != testClassA {
+			t.Errorf("class a = %q, want previous value %q", data, testClassA)
+		}
+		if len(notified) != 1 || !slices.Equal(notified[0], []string{"c"}) {
+			t.Errorf("notified = %v, want [[c]]", notified)
+		}
+	})
+
+	t.Run("class defined more than once", func(t *testing.T) {
+		configMap.Data["c"] = testClassA
+		update(t, configMap)
+
+		if err := h.Update(); err == nil {
+			t.Fatalf("expected Update() to fail with a class defined more than once")
+		}
+		if data, _ := h.GetDataForClass("c"); string(data) != testClassB {
+			t.Errorf("class c = %q, want previous value %q", data, testClassB)
+		}
+	})
+
+	t.Run("no classes keeps previous data", func(t *testing.T) {
+		for _, obj := range []client.Object{secret, configMap} {
+			if err := c.Delete(ctx, obj); err != nil {
+				t.Fatalf("failed to delete %T: %v", obj, err)
+			}
+		}
+
+		if err := h.Update(); err == nil {
+			t.Fatalf("expected Update() to fail without any classes")
+		}
+		if _, ok := h.GetDataForClass("b"); !ok {
+			t.Errorf("expected class b to still exist")
+		}
+	})
+}
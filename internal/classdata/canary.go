@@ -0,0 +1,38 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"github.com/BurntSushi/toml"
+)
+
+// CanarySuffix is appended to the name of a class to name its canary revision,
+// e.g. default.canary is the canary revision of the default class. Keys of
+// Secrets and ConfigMaps, and the names of resources, can't contain characters
+// such as "@", which is why a suffix is used.
+const CanarySuffix = ".canary"
+
+// CanaryWeight returns the percentage of the pods using a class that its canary
+// revision is rolled out to, given the data of the canary class.
+func CanaryWeight(data []byte) int {
+	var cfg operatorConfig
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return 0
+	}
+
+	return cfg.Operator.Canary
+}
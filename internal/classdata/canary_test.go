@@ -0,0 +1,55 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewDirectoryHandler_CanaryClasses(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), testClassA)
+	writeFile(t, filepath.Join(dir, "a.canary"), "[operator]\n  canary = 25\n"+testClassB)
+	writeFile(t, filepath.Join(dir, "b.canary"), testClassB)
+
+	h, err := NewDirectoryHandler(dir)
+	if err != nil {
+		t.Fatalf("NewDirectoryHandler() error = %v", err)
+	}
+
+	tests := []struct {
+		class string
+		want  int
+	}{
+		{class: "a", want: 0},
+		{class: "a.canary", want: 25},
+		{class: "b.canary", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			data, ok := h.GetDataForClass(tt.class)
+			if !ok {
+				t.Fatalf("expected class %q to exist", tt.class)
+			}
+			if got := CanaryWeight(data); got != tt.want {
+				t.Errorf("CanaryWeight() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,42 +17,123 @@ limitations under the License.
 package classdata
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/influxdata/toml"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultWatchDebounce = time.Second
 )
 
 type Handler interface {
 	GetDataForClass(name string) ([]byte, bool)
 	Update() error
+	// Subscribe registers fn to be called with the names of all classes that
+	// were added, changed or removed after the class data has been updated.
+	Subscribe(fn func(classes []string))
+	// HasSynced returns whether the classes have been loaded from their source.
+	// Until then no class exists, and rendering a configuration would apply
+	// the missing class policy to every class.
+	HasSynced() bool
+}
+
+type subscribers struct {
+	mu  sync.Mutex
+	fns []func(classes []string)
+}
+
+func (s *subscribers) Subscribe(fn func(classes []string)) {
+	s.mu.Lock()
+	s.fns = append(s.fns, fn)
+	s.mu.Unlock()
+}
+
+func (s *subscribers) notify(classes []string) {
+	if len(classes) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	fns := slices.Clone(s.fns)
+	s.mu.Unlock()
+
+	for _, fn := range fns {
+		fn(classes)
+	}
+}
+
+// changedClasses returns the sorted names of all classes that differ between old and new.
+func changedClasses(old, new map[string][]byte) []string {
+	var changed []string
+	for name, data := range new {
+		if prev, ok := old[name]; !ok || !bytes.Equal(prev, data) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+
+	return changed
 }
 
 type DirectoryHandler struct {
-	data map[string][]byte
-	path string
-	mu   sync.RWMutex
+	subscribers
+
+	data     map[string][]byte
+	path     string
+	mu       sync.RWMutex
+	debounce time.Duration
+	loader   *loader
 }
 
-func NewDirectoryHandler(path string) (*DirectoryHandler, error) {
+// NewDirectoryHandler reads the classes in the directory at path. Classes that
+// fail validation are logged and unavailable, an error is only returned if none
+// of the classes are valid.
+func NewDirectoryHandler(path string, opts ...Option) (*DirectoryHandler, error) {
 	handler := &DirectoryHandler{
-		path: path,
-		data: make(map[string][]byte),
+		path:     path,
+		debounce: defaultWatchDebounce,
+		loader:   newLoader(opts...),
 	}
 
-	if err := handler.readClassData(); err != nil {
+	files, err := handler.readClassData()
+	if err != nil {
 		return nil, fmt.Errorf("failed to read telegaf class data: %w", err)
 	}
 
-	if err := handler.validate(); err != nil {
-		return nil, fmt.Errorf("failed to validate telegraf class data: %w", err)
+	data, failed := handler.loader.convertClassData(files)
+	data, err = handler.loader.prepare(data, failed, nil)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("failed to validate telegraf class data, no valid classes could be found: %w", err)
+	}
+	if err != nil {
+		logf.Log.WithName("classdata").Error(err, "some telegraf classes failed validation and are unavailable")
 	}
+	handler.data = data
 
 	return handler, nil
 }
 
+// HasSynced always returns true, the class directory is read by
+// NewDirectoryHandler.
+func (h *DirectoryHandler) HasSynced() bool {
+	return true
+}
+
 func (h *DirectoryHandler) GetDataForClass(name string) ([]byte, bool) {
 	h.mu.RLock()
 	data, ok := h.data[name]
@@ -61,65 +142,133 @@ func (h *DirectoryHandler) GetDataForClass(name string) ([]byte, bool) {
 	return data, ok
 }
 
+// Update re-reads the class directory. The previous class data is kept if the
+// directory can't be read, and the previous version of every class failing
+// validation is kept, while the other classes are updated.
 func (h *DirectoryHandler) Update() error {
-	// Make a copy of the current data in case the update fails
-	cp := make(map[string][]byte)
-	h.mu.RLock()
-	for k, v := range h.data {
-		cp[k] = v
+	files, err := h.readClassData()
+	if err != nil {
+		return fmt.Errorf("failed to update class data: %w", err)
 	}
+	if len(files) == 0 {
+		return fmt.Errorf("failed to update class data, no data could be found, keeping previous")
+	}
+
+	h.mu.RLock()
+	previous := h.data
 	h.mu.RUnlock()
 
+	data, failed := h.loader.convertClassData(files)
+	data, errs := h.loader.prepare(data, failed, previous)
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	if err := h.readClassData(); err != nil {
-		h.data = cp
-		return fmt.Errorf("failed to update class data: %w", err)
-	}
+	changed := changedClasses(h.data, data)
+	h.data = data
+	h.mu.Unlock()
+
+	h.notify(changed)
 
-	if err := h.validate(); err != nil {
-		h.data = cp
-		return fmt.Errorf("failed to validate updated class data, reverting to previous: %w", err)
+	if errs != nil {
+		return fmt.Errorf("failed to validate updated class data, keeping previous version of invalid classes: %w", errs)
 	}
 
 	return nil
 }
 
-func (h *DirectoryHandler) validate() error {
-	if len(h.data) == 0 {
-		return fmt.Errorf("failed to validate class data, no data could be found")
+// Start watches the class directory and calls Update whenever its contents
+// change, until ctx is cancelled. It implements manager.Runnable.
+func (h *DirectoryHandler) Start(ctx context.Context) error {
+	log := logf.Log.WithName("classdata").WithValues("path", h.path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create class directory watcher: %w", err)
+	}
+	defer watcher.Close() //nolint:errcheck
+
+	// Secret and ConfigMap volumes are updated by the kubelet by atomically
+	// swapping the ..data symlink, which only shows up as an event on the
+	// directory itself, so the directory is watched rather than the files.
+	if err := watcher.Add(h.path); err != nil {
+		return fmt.Errorf("failed to watch class directory: %s, error: %w", h.path, err)
 	}
 
-	for file, data := range h.data {
-		if _, err := toml.Parse(data); err != nil {
-			return fmt.Errorf("failed to validate class data for file: %s, error: %w", file, err)
+	// A single volume update produces a burst of events, wait for the burst
+	// to settle before reloading.
+	timer := time.NewTimer(h.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			timer.Reset(h.debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "error watching class directory")
+		case <-timer.C:
+			if err := h.Update(); err != nil {
+				log.Error(err, "failed to reload class data")
+				continue
+			}
+			log.Info("reloaded class data")
 		}
 	}
+}
+
+// NeedLeaderElection ensures the class data is kept up to date on every
+// replica, so that a replica taking over leadership isn't serving stale data.
+func (h *DirectoryHandler) NeedLeaderElection() bool {
+	return false
+}
+
+// Validate checks that data is a valid telegraf class configuration.
+func Validate(data []byte) error {
+	if _, err := toml.Parse(data); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (h *DirectoryHandler) readClassData() error {
+// NamespacedClassName returns the name under which a Handler serves a class
+// that is only available to pods in the given namespace.
+func NamespacedClassName(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func (h *DirectoryHandler) readClassData() (map[string][]byte, error) {
 	files, err := os.ReadDir(h.path)
 	if err != nil {
-		return fmt.Errorf("failed to read directory: %s, error: %w", h.path, err)
+		return nil, fmt.Errorf("failed to read directory: %s, error: %w", h.path, err)
 	}
 
+	data := make(map[string][]byte)
 	for _, file := range files {
 		fpath := filepath.Join(h.path, file.Name())
 		stat, err := os.Stat(fpath)
 		if err != nil {
-			return fmt.Errorf("failed to stat: %s, error: %w", file.Name(), err)
+			return nil, fmt.Errorf("failed to stat: %s, error: %w", file.Name(), err)
 		}
 
 		if stat.Mode().IsRegular() {
-			data, err := os.ReadFile(fpath)
+			content, err := os.ReadFile(fpath)
 			if err != nil {
-				return fmt.Errorf("failed to read data from file: %s, error: %w", file.Name(), err)
+				return nil, fmt.Errorf("failed to read data from file: %s, error: %w", file.Name(), err)
 			}
-			h.data[file.Name()] = data
+			data[file.Name()] = content
 		}
 	}
 
-	return nil
+	return data, nil
 }
@@ -1 +1,262 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
 package classdata
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClassA = "[[outputs.file]]\n  files = [\"stdout\"]\n"
+	testClassB = "[[outputs.file]]\n  files = [\"stderr\"]\n"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// writeAtomicDir mimics the kubelet atomic writer used for Secret and
+// ConfigMap volumes: the files are symlinks into ..data, which itself is a
+// symlink to a timestamped directory that is swapped on every update.
+func writeAtomicDir(t *testing.T, dir, version string, files map[string]string) {
+	t.Helper()
+
+	tsDir := filepath.Join(dir, "..ts_"+version)
+	if err := os.Mkdir(tsDir, 0o755); err != nil {
+		t.Fatalf("failed to create %s: %v", tsDir, err)
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(tsDir, name), content)
+	}
+
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(tsDir), tmpLink); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("failed to swap ..data symlink: %v", err)
+	}
+
+	for name := range files {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join("..data", name), link); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+}
+
+func TestNewDirectoryHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantClasses []string
+		wantErr     bool
+	}{
+		{
+			name:        "valid classes",
+			files:       map[string]string{"a": testClassA, "b": testClassB},
+			wantClasses: []string{"a", "b"},
+		},
+		{
+			name:    "empty directory",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:        "invalid toml leaves out the class",
+			files:       map[string]string{"a": testClassA, "broken": "[[outputs.file"},
+			wantClasses: []string{"a"},
+		},
+		{
+			name:    "no valid classes",
+			files:   map[string]string{"broken": "[[outputs.file"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(dir, name), content)
+			}
+
+			h, err := NewDirectoryHandler(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewDirectoryHandler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			for name, content := range tt.files {
+				data, ok := h.GetDataForClass(name)
+				if ok != slices.Contains(tt.wantClasses, name) {
+					t.Errorf("class %q exists = %v, want %v", name, ok, !ok)
+				}
+				if ok && string(data) != content {
+					t.Errorf("class %q = %q, want %q", name, data, content)
+				}
+			}
+		})
+	}
+}
+
+func TestDirectoryHandler_Update(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), testClassA)
+	writeFile(t, filepath.Join(dir, "b"), testClassA)
+
+	h, err := NewDirectoryHandler(dir)
+	if err != nil {
+		t.Fatalf("NewDirectoryHandler() error = %v", err)
+	}
+
+	var notified [][]string
+	h.Subscribe(func(classes []string) {
+		notified = append(notified, classes)
+	})
+
+	t.Run("changed, added and removed classes are notified", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "a"), testClassB)
+		writeFile(t, filepath.Join(dir, "c"), testClassA)
+		if err := os.Remove(filepath.Join(dir, "b")); err != nil {
+			t.Fatalf("failed to remove class: %v", err)
+		}
+
+		if err := h.Update(); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		if len(notified) != 1 || !slices.Equal(notified[0], []string{"a", "b", "c"}) {
+			t.Errorf("notified = %v, want [[a b c]]", notified)
+		}
+		if _, ok := h.GetDataForClass("b"); ok {
+			t.Errorf("expected removed class to no longer exist")
+		}
+		if data, _ := h.GetDataForClass("a"); string(data) != testClassB {
+			t.Errorf("class a = %q, want %q", data, testClassB)
+		}
+	})
+
+	t.Run("no notification without changes", func(t *testing.T) {
+		notified = nil
+		if err := h.Update(); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if len(notified) != 0 {
+			t.Errorf("notified = %v, want none", notified)
+		}
+	})
+
+	t.Run("invalid update keeps previous data", func(t *testing.T) {
+		notified = nil
+		writeFile(t, filepath.Join(dir, "a"), "[[outputs.file")
+
+		if err := h.Update(); err == nil {
+			t.Fatalf("expected Update() to fail with invalid class data")
+		}
+		if data, _ := h.GetDataForClass("a"); string(data) != testClassB {
+			t.Errorf("class a = %q, want previous value %q", data, testClassB)
+		}
+		if len(notified) != 0 {
+			t.Errorf("notified = %v, want none", notified)
+		}
+	})
+
+	t.Run("invalid class doesn't block other classes", func(t *testing.T) {
+		notified = nil
+		writeFile(t, filepath.Join(dir, "c"), testClassB)
+		writeFile(t, filepath.Join(dir, "d"), "[[outputs.file")
+
+		if err := h.Update(); err == nil {
+			t.Fatalf("expected Update() to fail with invalid class data")
+		}
+		if data, _ := h.GetDataForClass("c"); string(data) != testClassB {
+			t.Errorf("class c = %q, want %q", data, testClassB)
+		}
+		if _, ok := h.GetDataForClass("d"); ok {
+			t.Errorf("expected invalid new class to not exist")
+		}
+		if len(notified) != 1 || !slices.Equal(notified[0], []string{"c"}) {
+			t.Errorf("notified = %v, want [[c]]", notified)
+		}
+	})
+}
+
+func TestDirectoryHandler_Start(t *testing.T) {
+	dir := t.TempDir()
+	writeAtomicDir(t, dir, "1", map[string]string{"a": testClassA})
+
+	h, err := NewDirectoryHandler(dir)
+	if err != nil {
+		t.Fatalf("NewDirectoryHandler() error = %v", err)
+	}
+	h.debounce = 50 * time.Millisecond
+
+	var mu sync.Mutex
+	var notified []string
+	h.Subscribe(func(classes []string) {
+		mu.Lock()
+		notified = append(notified, classes...)
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- h.Start(ctx)
+	}()
+
+	// Give the watcher time to register before updating the directory.
+	time.Sleep(100 * time.Millisecond)
+	writeAtomicDir(t, dir, "2", map[string]string{"a": testClassB})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := h.GetDataForClass("a"); string(data) == testClassB {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for class data to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	if !slices.Equal(notified, []string{"a"}) {
+		t.Errorf("notified = %v, want [a]", notified)
+	}
+	mu.Unlock()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error = %v", err)
+	}
+}
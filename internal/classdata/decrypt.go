@@ -0,0 +1,377 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	sopsage "github.com/getsops/sops/v3/age"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ageExtension is the extension of age encrypted class files.
+	ageExtension = ".age"
+	ageHeader    = "age-encryption.org/v1\n"
+
+	sopsMetadataKey = "sops"
+	// sopsBinaryDataKey holds the content of files SOPS doesn't know the
+	// format of, such as TOML classes.
+	sopsBinaryDataKey = "data"
+)
+
+var (
+	// sopsValuePattern matches the values SOPS encrypted with AES-GCM.
+	sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+	// sopsMACOnlyEncryptedInitialization is written to the hash of the MAC
+	// before the values of files that only authenticate their encrypted values.
+	sopsMACOnlyEncryptedInitialization = []byte{
+		0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b,
+		0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69,
+	}
+	// sopsTypeTags are the YAML tags of the types of SOPS encrypted values.
+	sopsTypeTags = map[string]string{
+		"str":   "!!str",
+		"bytes": "!!str",
+		"int":   "!!int",
+		"float": "!!float",
+		"bool":  "!!bool",
+		"time":  "!!timestamp",
+	}
+)
+
+// sopsMetadata is the part of the metadata of a SOPS encrypted file that is
+// needed to decrypt it with an age key.
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	KeyGroups        []any  `yaml:"key_groups"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// LoadAgeIdentities reads the age identities in the file at path, in the format
+// written by age-keygen.
+func LoadAgeIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open age key file: %s, error: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age key file: %s, error: %w", path, err)
+	}
+
+	return identities, nil
+}
+
+// decrypt returns the plaintext of an age or SOPS encrypted class, or data as
+// is if it isn't encrypted. The plaintext of a SOPS encrypted class is YAML if
+// the class is written in a structured format.
+func (l *loader) decrypt(data []byte, structured bool) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte(armor.Header)):
+		return l.decryptAge(armor.NewReader(bytes.NewReader(trimmed)))
+	case bytes.HasPrefix(data, []byte(ageHeader)):
+		return l.decryptAge(bytes.NewReader(data))
+	}
+
+	// TOML classes aren't valid YAML, which is how they are told apart from
+	// the SOPS files.
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return data, nil
+	}
+	if _, ok := doc[sopsMetadataKey].(map[string]any); !ok {
+		return data, nil
+	}
+
+	return l.decryptSOPS(trimmed, structured)
+}
+
+func (l *loader) decryptAge(src io.Reader) ([]byte, error) {
+	if len(l.identities) == 0 {
+		return nil, fmt.Errorf("class is encrypted, but no age key is configured")
+	}
+
+	r, err := age.Decrypt(src, l.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt age encrypted class: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt age encrypted class: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// decryptSOPS decrypts a SOPS encrypted YAML or JSON class, in the same way as
+// `sops decrypt`. Only the age key source of SOPS is used, the SOPS library
+// would otherwise pull in the clients of every cloud KMS. The values are
+// verified against the MAC of the file, so that they can't be removed or
+// swapped without being detected.
+func (l *loader) decryptSOPS(data []byte, structured bool) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode SOPS encrypted class: %w", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("SOPS encrypted class must be a single mapping")
+	}
+	root := doc.Content[0]
+
+	i := slices.IndexFunc(root.Content, func(n *yaml.Node) bool { return n.Value == sopsMetadataKey })
+	if i < 0 || i%2 != 0 {
+		return nil, fmt.Errorf("SOPS encrypted class is missing its metadata")
+	}
+	var metadata sopsMetadata
+	if err := root.Content[i+1].Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode SOPS metadata: %w", err)
+	}
+	root.Content = slices.Delete(root.Content, i, i+2)
+
+	dataKey, err := l.sopsDataKey(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &sopsDecrypter{key: dataKey, hash: sha512.New(), macOnlyEncrypted: metadata.MACOnlyEncrypted}
+	if d.macOnlyEncrypted {
+		d.hash.Write(sopsMACOnlyEncryptedInitialization)
+	}
+	if err := d.walk(root, nil); err != nil {
+		return nil, err
+	}
+
+	lastModified, err := time.Parse(time.RFC3339, metadata.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SOPS lastmodified: %s, error: %w", metadata.LastModified, err)
+	}
+	mac, _, err := decryptSOPSValue(metadata.MAC, dataKey, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SOPS MAC: %w", err)
+	}
+	if mac != fmt.Sprintf("%X", d.hash.Sum(nil)) {
+		return nil, fmt.Errorf("failed to verify SOPS encrypted class, the MAC doesn't match its values")
+	}
+
+	if structured {
+		plaintext, err := yaml.Marshal(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode decrypted class: %w", err)
+		}
+		return plaintext, nil
+	}
+
+	if len(root.Content) != 2 || root.Content[0].Value != sopsBinaryDataKey || root.Content[1].Tag != "!!str" {
+		return nil, fmt.Errorf("SOPS encrypted class is missing the %q key, encrypt TOML classes with "+
+			"--input-type binary", sopsBinaryDataKey)
+	}
+
+	return []byte(root.Content[1].Value), nil
+}
+
+// sopsDataKey returns the data key of a SOPS encrypted file, decrypted with the
+// age identities of the loader.
+func (l *loader) sopsDataKey(metadata sopsMetadata) ([]byte, error) {
+	if len(metadata.KeyGroups) > 0 {
+		return nil, fmt.Errorf("SOPS key groups aren't supported, encrypt the class for age recipients only")
+	}
+	if len(metadata.Age) == 0 {
+		return nil, fmt.Errorf("SOPS encrypted class isn't encrypted for an age recipient")
+	}
+	if len(l.identities) == 0 {
+		return nil, fmt.Errorf("class is encrypted, but no age key is configured")
+	}
+
+	var errs []error
+	for _, recipient := range metadata.Age {
+		key := &sopsage.MasterKey{Recipient: recipient.Recipient, EncryptedKey: recipient.Enc}
+		sopsage.ParsedIdentities(l.identities).ApplyToMasterKey(key)
+		dataKey, err := key.Decrypt()
+		if err == nil {
+			return dataKey, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, fmt.Errorf("failed to decrypt SOPS data key, error: %w", errors.Join(errs...))
+}
+
+// sopsDecrypter decrypts the values of a SOPS encrypted file in place, and
+// hashes them in the order SOPS computes the MAC of the file in.
+type sopsDecrypter struct {
+	key              []byte
+	hash             hash.Hash
+	macOnlyEncrypted bool
+}
+
+// walk decrypts the values of node. Values are authenticated with the keys of
+// the mappings they are in, the items of a sequence share the path of the
+// sequence.
+func (d *sopsDecrypter) walk(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := d.walk(node.Content[i+1], append(slices.Clone(path), node.Content[i].Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := d.walk(item, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return d.decryptScalar(node, path)
+	default:
+		return fmt.Errorf("unsupported YAML node in SOPS encrypted class: %s", strings.Join(path, "."))
+	}
+
+	return nil
+}
+
+func (d *sopsDecrypter) decryptScalar(node *yaml.Node, path []string) error {
+	encrypted := node.Tag == "!!str" && sopsValuePattern.MatchString(node.Value)
+
+	var value any
+	if encrypted {
+		plaintext, valueType, err := decryptSOPSValue(node.Value, d.key, strings.Join(path, ":")+":")
+		if err != nil {
+			return fmt.Errorf("failed to decrypt SOPS value: %s, error: %w", strings.Join(path, "."), err)
+		}
+		if value, err = parseSOPSValue(plaintext, valueType); err != nil {
+			return fmt.Errorf("failed to decrypt SOPS value: %s, error: %w", strings.Join(path, "."), err)
+		}
+		node.Value, node.Tag, node.Style = plaintext, sopsTypeTags[valueType], 0
+	} else if err := node.Decode(&value); err != nil {
+		return fmt.Errorf("failed to decode value: %s, error: %w", strings.Join(path, "."), err)
+	}
+
+	// Null values aren't part of the MAC.
+	if value == nil || (d.macOnlyEncrypted && !encrypted) {
+		return nil
+	}
+	b, err := sopsMACBytes(value)
+	if err != nil {
+		return fmt.Errorf("failed to hash value: %s, error: %w", strings.Join(path, "."), err)
+	}
+	d.hash.Write(b)
+
+	return nil
+}
+
+// decryptSOPSValue returns the plaintext and type of a value encrypted by SOPS.
+func decryptSOPSValue(value string, key []byte, additionalData string) (string, string, error) {
+	matches := sopsValuePattern.FindStringSubmatch(value)
+	if matches == nil {
+		return "", "", fmt.Errorf("value isn't in the SOPS format")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(matches[i+1]); err != nil {
+			return "", "", fmt.Errorf("failed to decode value: %w", err)
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), matches[4], nil
+}
+
+// parseSOPSValue returns the value of the plaintext of a SOPS encrypted value.
+func parseSOPSValue(plaintext, valueType string) (any, error) {
+	switch valueType {
+	case "str", "bytes":
+		return plaintext, nil
+	case "int":
+		return strconv.Atoi(plaintext)
+	case "float":
+		return strconv.ParseFloat(plaintext, 64)
+	case "bool":
+		return strconv.ParseBool(plaintext)
+	case "time":
+		var t time.Time
+		err := t.UnmarshalText([]byte(plaintext))
+		return t, err
+	default:
+		return nil, fmt.Errorf("unknown SOPS value type: %s", valueType)
+	}
+}
+
+// sopsMACBytes returns the representation of a value that SOPS hashes into the
+// MAC of a file.
+func sopsMACBytes(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case int:
+		return []byte(strconv.Itoa(v)), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case bool:
+		if v {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	case time.Time:
+		return v.MarshalText()
+	default:
+		return nil, fmt.Errorf("unsupported value type: %T", value)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// The SOPS test data is encrypted by the sops CLI for the age key in
+// testdata/sops/keys.txt, other-keys.txt holds a key it isn't encrypted for.
+// payments-tampered.yaml is payments.yaml with one of its values removed,
+// payments-partial.yaml only has its token encrypted, and so does
+// payments-mac-only.yaml, whose MAC only covers the encrypted values.
+const (
+	sopsTestData = "testdata/sops"
+
+	sopsTOMLClass = `[agent]
+  interval = "10s"
+
+[[outputs.influxdb_v2]]
+  urls = ["http://influxdb:8086"]
+  token = "secret-token"
+`
+	sopsYAMLClass = `agent:
+  metric_batch_size: 1000
+  omit_hostname: true
+outputs:
+  influxdb_v2:
+    - urls: ["http://influxdb:8086"]
+      token: secret-token
+      timeout: 5.5
+`
+)
+
+func newTestIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate age identity: %v", err)
+	}
+
+	return identity
+}
+
+func encryptAge(t *testing.T, recipient age.Recipient, plaintext string, armored bool) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var dst io.WriteCloser = nopWriteCloser{&buf}
+	if armored {
+		dst = armor.NewWriter(&buf)
+	}
+	w, err := age.Encrypt(dst, recipient)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if _, err := io.WriteString(w, plaintext); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func loadSOPSTestKeys(t *testing.T, name string) []age.Identity {
+	t.Helper()
+	identities, err := LoadAgeIdentities(filepath.Join(sopsTestData, name))
+	if err != nil {
+		t.Fatalf("LoadAgeIdentities() error = %v", err)
+	}
+
+	return identities
+}
+
+func readSOPSTestData(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(sopsTestData, name))
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+
+	return data
+}
+
+func TestLoader_Decrypt(t *testing.T) {
+	identity := newTestIdentity(t)
+	otherIdentity := newTestIdentity(t)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		structured bool
+		identities []age.Identity
+		sopsKey    string
+		want       string
+		wantErr    string
+	}{
+		{
+			name: "plain TOML class",
+			data: []byte(testClassA),
+			want: testClassA,
+		},
+		{
+			name:       "plain YAML class",
+			data:       []byte(sopsYAMLClass),
+			structured: true,
+			want:       sopsYAMLClass,
+		},
+		{
+			name:       "armored age",
+			data:       encryptAge(t, identity.Recipient(), testClassA, true),
+			identities: []age.Identity{identity},
+			want:       testClassA,
+		},
+		{
+			name:       "binary age",
+			data:       encryptAge(t, identity.Recipient(), testClassA, false),
+			identities: []age.Identity{otherIdentity, identity},
+			want:       testClassA,
+		},
+		{
+			name:    "age without key",
+			data:    encryptAge(t, identity.Recipient(), testClassA, true),
+			wantErr: "no age key is configured",
+		},
+		{
+			name:       "age with wrong key",
+			data:       encryptAge(t, identity.Recipient(), testClassA, true),
+			identities: []age.Identity{otherIdentity},
+			wantErr:    "failed to decrypt age encrypted class",
+		},
+		{
+			name:    "SOPS binary written as YAML",
+			data:    readSOPSTestData(t, "default"),
+			sopsKey: "keys.txt",
+			want:    sopsTOMLClass,
+		},
+		{
+			name:    "SOPS binary written as JSON",
+			data:    readSOPSTestData(t, "default-json"),
+			sopsKey: "keys.txt",
+			want:    sopsTOMLClass,
+		},
+		{
+			name:       "SOPS YAML",
+			data:       readSOPSTestData(t, "payments.yaml"),
+			structured: true,
+			sopsKey:    "keys.txt",
+			want:       sopsYAMLClass,
+		},
+		{
+			name:       "SOPS JSON",
+			data:       readSOPSTestData(t, "payments.json"),
+			structured: true,
+			sopsKey:    "keys.txt",
+			want:       sopsYAMLClass,
+		},
+		{
+			name:       "SOPS YAML with unencrypted values",
+			data:       readSOPSTestData(t, "payments-partial.yaml"),
+			structured: true,
+			sopsKey:    "keys.txt",
+			want:       sopsYAMLClass,
+		},
+		{
+			name:       "SOPS YAML with a MAC of the encrypted values",
+			data:       readSOPSTestData(t, "payments-mac-only.yaml"),
+			structured: true,
+			sopsKey:    "keys.txt",
+			want:       sopsYAMLClass,
+		},
+		{
+			name:    "SOPS YAML as a TOML class",
+			data:    readSOPSTestData(t, "payments.yaml"),
+			sopsKey: "keys.txt",
+			wantErr: "encrypt TOML classes with --input-type binary",
+		},
+		{
+			name:    "SOPS with wrong key",
+			data:    readSOPSTestData(t, "default"),
+			sopsKey: "other-keys.txt",
+			wantErr: "failed to decrypt SOPS data key",
+		},
+		{
+			name:    "SOPS without key",
+			data:    readSOPSTestData(t, "default"),
+			wantErr: "no age key is configured",
+		},
+		{
+			name:       "SOPS removed value",
+			data:       readSOPSTestData(t, "payments-tampered.yaml"),
+			structured: true,
+			sopsKey:    "keys.txt",
+			wantErr:    "the MAC doesn't match its values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identities := tt.identities
+			if tt.sopsKey != "" {
+				identities = append(identities, loadSOPSTestKeys(t, tt.sopsKey)...)
+			}
+
+			got, err := newLoader(WithAgeIdentities(identities...)).decrypt(tt.data, tt.structured)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decrypt() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt() error = %v", err)
+			}
+
+			if !tt.structured {
+				if string(got) != tt.want {
+					t.Errorf("decrypt() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+			// Decrypted YAML is re-encoded, compare the classes it converts to.
+			gotTOML, err := convertToTOML(got)
+			if err != nil {
+				t.Fatalf("failed to convert decrypted class: %v", err)
+			}
+			wantTOML, err := convertToTOML([]byte(tt.want))
+			if err != nil {
+				t.Fatalf("failed to convert class: %v", err)
+			}
+			if string(gotTOML) != string(wantTOML) {
+				t.Errorf("decrypt() = %q, want %q", gotTOML, wantTOML)
+			}
+		})
+	}
+}
+
+func TestNewDirectoryHandler_EncryptedClasses(t *testing.T) {
+	identity := newTestIdentity(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.age"), string(encryptAge(t, identity.Recipient(), testClassA, true)))
+	writeFile(t, filepath.Join(dir, "b"), string(readSOPSTestData(t, "default")))
+
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	writeFile(t, keyFile, "# created: 2024-06-01T12:00:00Z\n"+identity.String()+"\n")
+	identities, err := LoadAgeIdentities(keyFile)
+	if err != nil {
+		t.Fatalf("LoadAgeIdentities() error = %v", err)
+	}
+
+	identities = append(identities, loadSOPSTestKeys(t, "keys.txt")...)
+	h, err := NewDirectoryHandler(dir, WithAgeIdentities(identities...))
+	if err != nil {
+		t.Fatalf("NewDirectoryHandler() error = %v", err)
+	}
+	if data, _ := h.GetDataForClass("a"); string(data) != testClassA {
+		t.Errorf("class a = %q, want %q", data, testClassA)
+	}
+	if data, _ := h.GetDataForClass("b"); string(data) != sopsTOMLClass {
+		t.Errorf("class b = %q, want %q", data, sopsTOMLClass)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"sigs.k8s.io/yaml"
+)
+
+// structuredExtensions are the file extensions of classes that are written as
+// YAML or JSON rather than TOML. JSON is a subset of YAML, so both are parsed
+// the same way.
+var structuredExtensions = []string{".yaml", ".yml", ".json"}
+
+// className returns the name of the class defined in file, and whether the
+// file is written in a structured format that needs to be converted to TOML.
+// The extension of age encrypted files is ignored.
+func className(file string) (string, bool) {
+	file = strings.TrimSuffix(file, ageExtension)
+	ext := filepath.Ext(file)
+	for _, structured := range structuredExtensions {
+		if strings.EqualFold(ext, structured) {
+			return strings.TrimSuffix(file, ext), true
+		}
+	}
+
+	return file, false
+}
+
+// convertToTOML converts a class written in YAML or JSON to TOML.
+func convertToTOML(data []byte) ([]byte, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse class: %w", err)
+	}
+
+	// Numbers are decoded as json.Number, so that integers aren't turned into
+	// floats, which a number of telegraf plugin settings don't accept.
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode class: %w", err)
+	}
+
+	table, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("class must be a mapping, got: %T", value)
+	}
+	converted, err := convertNumbers(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(converted); err != nil {
+		return nil, fmt.Errorf("failed to encode class as TOML: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// convertNumbers replaces every json.Number in value by an int64, or by a
+// float64 if the number isn't an integer.
+func convertNumbers(value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s, error: %w", v, err)
+		}
+		return f, nil
+	case map[string]any:
+		for key, elem := range v {
+			converted, err := convertNumbers(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case []any:
+		for i, elem := range v {
+			converted, err := convertNumbers(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestConvertToTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]any
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			data: `
+agent:
+  interval: 10s
+  metric_batch_size: 1000
+  flush_jitter: 0.5
+  omit_hostname: true
+outputs:
+  file:
+    - files: ["stdout"]
+`,
+			want: map[string]any{
+				"agent": map[string]any{
+					"interval":          "10s",
+					"metric_batch_size": int64(1000),
+					"flush_jitter":      0.5,
+					"omit_hostname":     true,
+				},
+				"outputs": map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "json",
+			data: `{"outputs": {"file": [{"files": ["stdout"]}, {"files": ["stderr"]}]}}`,
+			want: map[string]any{
+				"outputs": map[string]any{"file": []map[string]any{
+					{"files": []any{"stdout"}},
+					{"files": []any{"stderr"}},
+				}},
+			},
+		},
+		{
+			name:    "not a mapping",
+			data:    "- a\n- b\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			data:    "agent: [",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := convertToTOML([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convertToTOML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := make(map[string]any)
+			if _, err := toml.Decode(string(data), &got); err != nil {
+				t.Fatalf("failed to decode converted class: %v, class: %q", err, data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("converted class = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDirectoryHandler_StructuredClasses(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), testClassA)
+	writeFile(t, filepath.Join(dir, "b.yaml"), "outputs:\n  file:\n    - files: [\"stderr\"]\n")
+	writeFile(t, filepath.Join(dir, "c.json"), `{"operator": {"extends": ["b"]}}`)
+
+	h, err := NewDirectoryHandler(dir)
+	if err != nil {
+		t.Fatalf("NewDirectoryHandler() error = %v", err)
+	}
+
+	for _, name := range []string{"b", "c"} {
+		data, ok := h.GetDataForClass(name)
+		if !ok {
+			t.Fatalf("expected class %q to exist", name)
+		}
+		if err := Validate(data); err != nil {
+			t.Errorf("class %q is not valid TOML: %v", name, err)
+		}
+	}
+	if _, ok := h.GetDataForClass("b.yaml"); ok {
+		t.Errorf("expected the class to be served without the file extension")
+	}
+
+	writeFile(t, filepath.Join(dir, "a.yml"), "outputs: {}\n")
+	if err := h.Update(); err == nil {
+		t.Fatalf("expected Update() to fail with a class defined by two files")
+	}
+}
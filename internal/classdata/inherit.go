@@ -0,0 +1,264 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// operatorTable is the reserved table in a class holding settings for the
+	// operator itself. Only the settings the operator needs when rendering a
+	// class are kept in the class data that is served.
+	operatorTable = "operator"
+)
+
+type operatorConfig struct {
+	Operator struct {
+		Extends []string `toml:"extends"`
+		// Abstract classes can only be extended, they aren't served themselves.
+		Abstract bool `toml:"abstract"`
+		// Canary is the percentage of pods a canary class is rolled out to.
+		Canary int `toml:"canary"`
+		// Template classes are rendered as a Go template for each pod, see
+		// IsTemplate.
+		Template bool `toml:"template"`
+		// Ancestors is set on resolved classes to every class they inherit
+		// from, see Ancestors.
+		Ancestors []string `toml:"ancestors"`
+	} `toml:"operator"`
+}
+
+// resolveInheritance returns the class data with the parent classes listed in
+// the operator table of each class merged in, in order, before the class itself.
+// Tables are merged recursively, any other value of a parent, including plugin
+// arrays, is replaced by the value of the class extending it.
+//
+// Classes that can't be resolved, because of a missing parent or an inheritance
+// cycle, are left out of the result and returned with their error. Abstract
+// classes are left out of the result as well. The operator table of a class is
+// kept with only the rollout percentage of a canary class, see CanaryWeight,
+// whether it is a template, see IsTemplate, and the classes it inherits from,
+// see Ancestors.
+func resolveInheritance(data map[string][]byte) (map[string][]byte, map[string]error) {
+	r := &inheritanceResolver{
+		data:      data,
+		resolved:  make(map[string]map[string]any),
+		abstract:  make(map[string]bool),
+		canary:    make(map[string]int),
+		template:  make(map[string]bool),
+		ancestors: make(map[string][]string),
+	}
+
+	resolved := make(map[string][]byte, len(data))
+	failed := make(map[string]error)
+	for _, name := range slices.Sorted(maps.Keys(data)) {
+		table, operator, err := r.resolve(name)
+		if err != nil {
+			failed[name] = fmt.Errorf("failed to resolve class: %s, error: %w", name, err)
+			continue
+		}
+		if r.abstract[name] {
+			continue
+		}
+
+		// Classes without an operator table are served as is.
+		if !operator {
+			resolved[name] = data[name]
+			continue
+		}
+		operatorSettings := make(map[string]any)
+		if strings.HasSuffix(name, CanarySuffix) {
+			operatorSettings["canary"] = r.canary[name]
+		}
+		if r.template[name] {
+			operatorSettings["template"] = true
+		}
+		if ancestors := r.ancestors[name]; len(ancestors) > 0 {
+			operatorSettings["ancestors"] = ancestors
+		}
+		if len(operatorSettings) > 0 {
+			table = maps.Clone(table)
+			table[operatorTable] = operatorSettings
+		}
+
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(table); err != nil {
+			failed[name] = fmt.Errorf("failed to encode class: %s, error: %w", name, err)
+			continue
+		}
+		resolved[name] = buf.Bytes()
+	}
+
+	return resolved, failed
+}
+
+type inheritanceResolver struct {
+	data      map[string][]byte
+	resolved  map[string]map[string]any
+	abstract  map[string]bool
+	canary    map[string]int
+	template  map[string]bool
+	ancestors map[string][]string
+	visiting  []string
+}
+
+// resolve returns the merged table of the class and whether the class has an
+// operator table.
+func (r *inheritanceResolver) resolve(name string) (map[string]any, bool, error) {
+	if i := slices.Index(r.visiting, name); i >= 0 {
+		cycle := append(slices.Clone(r.visiting[i:]), name)
+		return nil, false, fmt.Errorf("inheritance cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+
+	table := make(map[string]any)
+	if _, err := toml.Decode(string(r.data[name]), &table); err != nil {
+		return nil, false, fmt.Errorf("failed to decode class: %s, error: %w", name, err)
+	}
+	if _, ok := table[operatorTable]; !ok {
+		return table, false, nil
+	}
+	if resolved, ok := r.resolved[name]; ok {
+		return resolved, true, nil
+	}
+
+	var cfg operatorConfig
+	if _, err := toml.Decode(string(r.data[name]), &cfg); err != nil {
+		return nil, false, fmt.Errorf("invalid operator table in class: %s, error: %w", name, err)
+	}
+	delete(table, operatorTable)
+	r.abstract[name] = cfg.Operator.Abstract
+
+	if cfg.Operator.Canary != 0 && !strings.HasSuffix(name, CanarySuffix) {
+		return nil, false, fmt.Errorf("class: %s sets operator.canary, but isn't a canary class", name)
+	}
+	if cfg.Operator.Canary < 0 || cfg.Operator.Canary > 100 {
+		return nil, false, fmt.Errorf("canary class: %s has an invalid rollout percentage: %d", name, cfg.Operator.Canary)
+	}
+	r.canary[name] = cfg.Operator.Canary
+	r.template[name] = cfg.Operator.Template
+
+	r.visiting = append(r.visiting, name)
+	defer func() { r.visiting = r.visiting[:len(r.visiting)-1] }()
+
+	merged := make(map[string]any)
+	var ancestors []string
+	for _, parent := range cfg.Operator.Extends {
+		if !parentAllowed(name, parent) {
+			return nil, false, fmt.Errorf("class: %s extends class: %s of another namespace", name, parent)
+		}
+		parentName, ok := r.lookup(name, parent)
+		if !ok {
+			return nil, false, fmt.Errorf("class: %s extends unknown class: %s", name, parent)
+		}
+
+		parentTable, _, err := r.resolve(parentName)
+		if err != nil {
+			return nil, false, err
+		}
+		merged = mergeTables(merged, parentTable)
+		// The template expressions of a parent are rendered in the classes
+		// extending it as well.
+		r.template[name] = r.template[name] || r.template[parentName]
+
+		for _, ancestor := range append([]string{parentName}, r.ancestors[parentName]...) {
+			if !slices.Contains(ancestors, ancestor) {
+				ancestors = append(ancestors, ancestor)
+			}
+		}
+	}
+	merged = mergeTables(merged, table)
+	r.resolved[name] = merged
+	r.ancestors[name] = ancestors
+
+	return merged, true, nil
+}
+
+// lookup returns the name of the parent class of a class. A namespaced class
+// extends a class in its own namespace before falling back to a cluster class.
+func (r *inheritanceResolver) lookup(name, parent string) (string, bool) {
+	if namespace, _, ok := strings.Cut(name, "/"); ok {
+		if nsName := NamespacedClassName(namespace, parent); nsName != name {
+			if _, ok := r.data[nsName]; ok {
+				return nsName, true
+			}
+		}
+	}
+
+	_, ok := r.data[parent]
+	return parent, ok
+}
+
+// parentAllowed returns whether a class can extend parent, which is either a
+// cluster class or a class in the namespace of the class. The policy isn't
+// checked for namespaced ancestors, a class extending the class of another
+// namespace would inherit its outputs and credentials.
+func parentAllowed(name, parent string) bool {
+	parentNamespace, _, ok := strings.Cut(parent, "/")
+	if !ok {
+		return true
+	}
+	namespace, _, ok := strings.Cut(name, "/")
+
+	return ok && namespace == parentNamespace
+}
+
+// IsTemplate returns whether the class with data, or one of the classes it
+// inherits from, opted in to being rendered as a Go template.
+func IsTemplate(data []byte) bool {
+	var cfg operatorConfig
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return false
+	}
+
+	return cfg.Operator.Template
+}
+
+// Ancestors returns the names of every class the class with data inherits from,
+// directly or through its parents. Namespaced ancestors are named by
+// NamespacedClassName.
+func Ancestors(data []byte) []string {
+	var cfg operatorConfig
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return nil
+	}
+
+	return cfg.Operator.Ancestors
+}
+
+// mergeTables returns a new table with src merged on top of dst. Neither of the
+// tables is modified.
+func mergeTables(dst, src map[string]any) map[string]any {
+	merged := maps.Clone(dst)
+	for key, value := range src {
+		srcTable, srcOk := value.(map[string]any)
+		dstTable, dstOk := merged[key].(map[string]any)
+		if srcOk && dstOk {
+			merged[key] = mergeTables(dstTable, srcTable)
+			continue
+		}
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	testBaseClass = `
+[agent]
+  interval = "10s"
+  flush_interval = "10s"
+
+[[outputs.file]]
+  files = ["stdout"]
+`
+	testRegionClass = `
+[agent]
+  flush_interval = "30s"
+
+[global_tags]
+  region = "eu"
+`
+)
+
+func TestResolveInheritance(t *testing.T) {
+	tests := []struct {
+		name    string
+		classes map[string]string
+		class   string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name:    "class without operator table is unchanged",
+			classes: map[string]string{"a": testClassA},
+			class:   "a",
+			want: map[string]any{
+				"outputs": map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "parents are merged in order before the class",
+			classes: map[string]string{
+				"base":   testBaseClass,
+				"region": testRegionClass,
+				"child": `
+[operator]
+  extends = ["base", "region"]
+
+[agent]
+  interval = "1m"
+
+[[outputs.file]]
+  files = ["stderr"]
+`,
+			},
+			class: "child",
+			want: map[string]any{
+				"operator":    map[string]any{"ancestors": []any{"base", "region"}},
+				"agent":       map[string]any{"interval": "1m", "flush_interval": "30s"},
+				"global_tags": map[string]any{"region": "eu"},
+				"outputs":     map[string]any{"file": []map[string]any{{"files": []any{"stderr"}}}},
+			},
+		},
+		{
+			name: "parents extending other classes are resolved",
+			classes: map[string]string{
+				"base":   testBaseClass,
+				"region": "[operator]\n  extends = [\"base\"]\n" + testRegionClass,
+				"child":  "[operator]\n  extends = [\"region\"]\n",
+			},
+			class: "child",
+			want: map[string]any{
+				"operator":    map[string]any{"ancestors": []any{"region", "base"}},
+				"agent":       map[string]any{"interval": "10s", "flush_interval": "30s"},
+				"global_tags": map[string]any{"region": "eu"},
+				"outputs":     map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "namespaced class prefers a parent in its namespace",
+			classes: map[string]string{
+				"base":      testBaseClass,
+				"team/base": testRegionClass,
+				"team/app":  "[operator]\n  extends = [\"base\"]\n",
+			},
+			class: "team/app",
+			want: map[string]any{
+				"operator":    map[string]any{"ancestors": []any{"team/base"}},
+				"agent":       map[string]any{"flush_interval": "30s"},
+				"global_tags": map[string]any{"region": "eu"},
+			},
+		},
+		{
+			name: "namespaced class can extend the cluster class of the same name",
+			classes: map[string]string{
+				"base":      testBaseClass,
+				"team/base": "[operator]\n  extends = [\"base\"]\n" + testRegionClass,
+			},
+			class: "team/base",
+			want: map[string]any{
+				"operator":    map[string]any{"ancestors": []any{"base"}},
+				"agent":       map[string]any{"interval": "10s", "flush_interval": "30s"},
+				"global_tags": map[string]any{"region": "eu"},
+				"outputs":     map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "abstract class is only extended",
+			classes: map[string]string{
+				"base":  "[operator]\n  abstract = true\n" + testBaseClass,
+				"child": "[operator]\n  extends = [\"base\"]\n",
+			},
+			class: "child",
+			want: map[string]any{
+				"operator": map[string]any{"ancestors": []any{"base"}},
+				"agent":    map[string]any{"interval": "10s", "flush_interval": "10s"},
+				"outputs":  map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "namespaced class records the cluster classes it inherits from",
+			classes: map[string]string{
+				"base":       testBaseClass,
+				"restricted": "[operator]\n  extends = [\"base\"]\n" + testRegionClass,
+				"team/app":   "[operator]\n  extends = [\"restricted\"]\n",
+			},
+			class: "team/app",
+			want: map[string]any{
+				"operator":    map[string]any{"ancestors": []any{"restricted", "base"}},
+				"agent":       map[string]any{"interval": "10s", "flush_interval": "30s"},
+				"global_tags": map[string]any{"region": "eu"},
+				"outputs":     map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "canary class keeps its rollout percentage",
+			classes: map[string]string{
+				"base":        testBaseClass,
+				"base.canary": "[operator]\n  extends = [\"base\"]\n  canary = 20\n[agent]\n  interval = \"1m\"\n",
+			},
+			class: "base.canary",
+			want: map[string]any{
+				"operator": map[string]any{"canary": int64(20), "ancestors": []any{"base"}},
+				"agent":    map[string]any{"interval": "1m", "flush_interval": "10s"},
+				"outputs":  map[string]any{"file": []map[string]any{{"files": []any{"stdout"}}}},
+			},
+		},
+		{
+			name: "template setting is inherited",
+			classes: map[string]string{
+				"base":  "[operator]\n  template = true\n[global_tags]\n  namespace = \"{{ .Pod.Namespace }}\"\n",
+				"child": "[operator]\n  extends = [\"base\"]\n",
+			},
+			class: "child",
+			want: map[string]any{
+				"operator":    map[string]any{"template": true, "ancestors": []any{"base"}},
+				"global_tags": map[string]any{"namespace": "{{ .Pod.Namespace }}"},
+			},
+		},
+		{
+			name:    "canary percentage on a class that isn't a canary",
+			classes: map[string]string{"base": "[operator]\n  canary = 20\n" + testBaseClass},
+			class:   "base",
+			wantErr: "class: base sets operator.canary, but isn't a canary class",
+		},
+		{
+			name:    "invalid canary percentage",
+			classes: map[string]string{"base.canary": "[operator]\n  canary = 120\n" + testBaseClass},
+			class:   "base.canary",
+			wantErr: "canary class: base.canary has an invalid rollout percentage: 120",
+		},
+		{
+			name:    "missing parent",
+			classes: map[string]string{"child": "[operator]\n  extends = [\"missing\"]\n"},
+			class:   "child",
+			wantErr: "class: child extends unknown class: missing",
+		},
+		{
+			name: "namespaced class can't extend the class of another namespace",
+			classes: map[string]string{
+				"other/secret": testBaseClass,
+				"team/app":     "[operator]\n  extends = [\"other/secret\"]\n",
+			},
+			class:   "team/app",
+			wantErr: "class: team/app extends class: other/secret of another namespace",
+		},
+		{
+			name: "cluster class can't extend a namespaced class",
+			classes: map[string]string{
+				"team/base": testBaseClass,
+				"child":     "[operator]\n  extends = [\"team/base\"]\n",
+			},
+			class:   "child",
+			wantErr: "class: child extends class: team/base of another namespace",
+		},
+		{
+			name: "inheritance cycle",
+			classes: map[string]string{
+				"a": "[operator]\n  extends = [\"b\"]\n",
+				"b": "[operator]\n  extends = [\"a\"]\n",
+			},
+			class:   "a",
+			wantErr: "inheritance cycle detected: a -> b -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make(map[string][]byte)
+			for name, content := range tt.classes {
+				data[name] = []byte(content)
+			}
+
+			resolved, failed := resolveInheritance(data)
+			err := failed[tt.class]
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveInheritance() error = %v, want %q", err, tt.wantErr)
+				}
+				if _, ok := resolved[tt.class]; ok {
+					t.Errorf("expected class %q to be left out", tt.class)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveInheritance() error = %v", err)
+			}
+
+			got := make(map[string]any)
+			if _, err := toml.Decode(string(resolved[tt.class]), &got); err != nil {
+				t.Fatalf("failed to decode resolved class: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolved class = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDirectoryHandler_Inheritance(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "base"), testBaseClass)
+	writeFile(t, filepath.Join(dir, "child"), "[operator]\n  extends = [\"base\"]\n")
+
+	h, err := NewDirectoryHandler(dir)
+	if err != nil {
+		t.Fatalf("NewDirectoryHandler() error = %v", err)
+	}
+
+	data, _ := h.GetDataForClass("child")
+	if strings.Contains(string(data), "extends") {
+		t.Errorf("expected the operator settings of the class to be removed, got %q", data)
+	}
+	if ancestors := Ancestors(data); !reflect.DeepEqual(ancestors, []string{"base"}) {
+		t.Errorf("Ancestors() = %v, want [base]", ancestors)
+	}
+	if !strings.Contains(string(data), "stdout") {
+		t.Errorf("expected the output of the base class to be inherited, got %q", data)
+	}
+
+	writeFile(t, filepath.Join(dir, "child"), "[operator]\n  extends = [\"missing\"]\n")
+	if err := h.Update(); err == nil {
+		t.Fatalf("expected Update() to fail with a missing parent class")
+	}
+	if updated, _ := h.GetDataForClass("child"); string(updated) != string(data) {
+		t.Errorf("expected the previous version of the class to be kept, got %q", updated)
+	}
+}
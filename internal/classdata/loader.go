@@ -0,0 +1,160 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"filippo.io/age"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
+)
+
+// Option configures how a Handler loads classes.
+type Option func(*loader)
+
+// WithEnvVars allows classes to reference the named environment variables in
+// addition to the ones every sidecar has, e.g. variables added to the sidecars
+// through pod annotations.
+func WithEnvVars(names ...string) Option {
+	return func(l *loader) {
+		l.envVars.Insert(names...)
+	}
+}
+
+// WithPlugins allows classes to use the named plugins in addition to the ones
+// built into telegraf. Plugins are named by type, e.g. inputs.custom.
+func WithPlugins(names ...string) Option {
+	return func(l *loader) {
+		l.plugins.Insert(names...)
+	}
+}
+
+// WithAgeIdentities decrypts age and SOPS encrypted class files with the
+// identities.
+func WithAgeIdentities(identities ...age.Identity) Option {
+	return func(l *loader) {
+		l.identities = append(l.identities, identities...)
+	}
+}
+
+type loader struct {
+	envVars    sets.Set[string]
+	plugins    sets.Set[string]
+	identities []age.Identity
+}
+
+func newLoader(opts ...Option) *loader {
+	l := &loader{
+		envVars: sets.New(sidecarEnvVars...),
+		plugins: sets.New[string](),
+	}
+	for pluginType, names := range pluginCatalogue {
+		for _, name := range names {
+			l.plugins.Insert(pluginType + "." + name)
+		}
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// convertClassData returns the classes defined by a set of class files, with
+// encrypted classes decrypted, and the classes written in YAML or JSON converted
+// to TOML. Classes that can't be decrypted or converted, or are defined by more
+// than one file, are returned with their error instead.
+func (l *loader) convertClassData(files map[string][]byte) (map[string][]byte, map[string]error) {
+	data := make(map[string][]byte, len(files))
+	failed := make(map[string]error)
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		name, structured := className(file)
+		if _, ok := data[name]; ok {
+			failed[name] = fmt.Errorf("class: %s is defined by more than one file", name)
+			continue
+		}
+		if _, ok := failed[name]; ok {
+			continue
+		}
+
+		content, err := l.decrypt(files[file], structured)
+		if err != nil {
+			failed[name] = fmt.Errorf("failed to decrypt class file: %s, error: %w", file, err)
+			continue
+		}
+		if structured {
+			if content, err = convertToTOML(content); err != nil {
+				failed[name] = fmt.Errorf("failed to convert class file: %s, error: %w", file, err)
+				continue
+			}
+		}
+		data[name] = content
+	}
+
+	for name := range failed {
+		delete(data, name)
+	}
+
+	return data, failed
+}
+
+// prepare validates the class data read from a source and resolves the
+// inheritance between the classes. Classes that fail, including the ones in
+// failed, are reported in the returned error. The previous data of a failed
+// class is kept, so that a broken update doesn't make the class unavailable.
+func (l *loader) prepare(data map[string][]byte, failed map[string]error,
+	previous map[string][]byte) (map[string][]byte, error) {
+	if failed == nil {
+		failed = make(map[string]error)
+	}
+
+	valid := make(map[string][]byte, len(data))
+	for name, content := range data {
+		if err := Validate(content); err != nil {
+			failed[name] = fmt.Errorf("failed to parse class: %s, error: %w", name, err)
+			continue
+		}
+		valid[name] = content
+	}
+
+	resolved, resolveErrs := resolveInheritance(valid)
+	maps.Copy(failed, resolveErrs)
+
+	if featuregate.ClassValidation.IsEnabled() {
+		for name, content := range resolved {
+			if err := l.validateClass(content); err != nil {
+				failed[name] = fmt.Errorf("invalid class: %s, error: %w", name, err)
+				delete(resolved, name)
+			}
+		}
+	}
+
+	var errs error
+	for _, name := range slices.Sorted(maps.Keys(failed)) {
+		errs = multierr.Append(errs, failed[name])
+		if prev, ok := previous[name]; ok {
+			resolved[name] = prev
+		}
+	}
+
+	return resolved, errs
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jmickey/telegraf-sidecar-operator/api/v1alpha1"
+)
+
+// ResourceHandler serves class data from TelegrafClass and TelegrafNamespaceClass
+// resources. Namespaced classes are served under NamespacedClassName.
+type ResourceHandler struct {
+	subscribers
+
+	cache cache.Cache
+	// configs holds the configuration of every class resource as delivered by
+	// the informers, so that a change doesn't require listing every resource.
+	configs map[string][]byte
+	// configsMu also serializes updates, so that an update built from older
+	// configs can't replace the data of a newer one.
+	configsMu sync.Mutex
+	data      map[string][]byte
+	mu        sync.RWMutex
+	// watching is set once the initial list of resources has been delivered,
+	// and synced once the class data has been built from it.
+	watching atomic.Bool
+	synced   atomic.Bool
+	loader   *loader
+}
+
+func NewResourceHandler(c cache.Cache, opts ...Option) *ResourceHandler {
+	return &ResourceHandler{
+		cache:   c,
+		configs: make(map[string][]byte),
+		data:    make(map[string][]byte),
+		loader:  newLoader(opts...),
+	}
+}
+
+func (h *ResourceHandler) GetDataForClass(name string) ([]byte, bool) {
+	h.mu.RLock()
+	data, ok := h.data[name]
+	h.mu.RUnlock()
+
+	return data, ok
+}
+
+// HasSynced returns whether the class data has been built from the initial
+// list of class resources.
+func (h *ResourceHandler) HasSynced() bool {
+	return h.synced.Load()
+}
+
+// Update rebuilds the class data from the class resources delivered by the
+// informers. Classes that fail validation or can't be resolved are reported in
+// the returned error, and their previous version is kept, without affecting any
+// of the other classes.
+func (h *ResourceHandler) Update() error {
+	h.configsMu.Lock()
+	defer h.configsMu.Unlock()
+
+	h.mu.RLock()
+	previous := h.data
+	h.mu.RUnlock()
+
+	data, errs := h.loader.prepare(maps.Clone(h.configs), nil, previous)
+
+	h.mu.Lock()
+	changed := changedClasses(h.data, data)
+	h.data = data
+	h.mu.Unlock()
+
+	h.notify(changed)
+
+	return errs
+}
+
+// setClass records the configuration of a class resource, and removes it if
+// the resource was deleted.
+func (h *ResourceHandler) setClass(obj any, deleted bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	var name, config string
+	switch class := obj.(type) {
+	case *v1alpha1.TelegrafClass:
+		name, config = class.Name, class.Spec.Config
+	case *v1alpha1.TelegrafNamespaceClass:
+		name, config = NamespacedClassName(class.Namespace, class.Name), class.Spec.Config
+	default:
+		return
+	}
+
+	h.configsMu.Lock()
+	if deleted {
+		delete(h.configs, name)
+	} else {
+		h.configs[name] = []byte(config)
+	}
+	h.configsMu.Unlock()
+}
+
+// Start registers informers for the class resources and updates the class
+// data whenever one of them changes, until ctx is cancelled. It implements
+// manager.Runnable.
+func (h *ResourceHandler) Start(ctx context.Context) error {
+	log := logf.Log.WithName("classdata")
+
+	reload := func() {
+		if err := h.Update(); err != nil {
+			log.Error(err, "failed to update class data from class resources")
+		}
+	}
+	// The class data is only built once the initial list of resources has
+	// been delivered, rather than once for each of them.
+	onChange := func(obj any, deleted bool) {
+		h.setClass(obj, deleted)
+		if h.watching.Load() {
+			reload()
+		}
+	}
+	eventHandler := toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { onChange(obj, false) },
+		UpdateFunc: func(_, obj any) { onChange(obj, false) },
+		DeleteFunc: func(obj any) { onChange(obj, true) },
+	}
+
+	var registrations []toolscache.InformerSynced
+	for _, obj := range []client.Object{&v1alpha1.TelegrafClass{}, &v1alpha1.TelegrafNamespaceClass{}} {
+		informer, err := h.cache.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("failed to get informer for %T: %w", obj, err)
+		}
+		registration, err := informer.AddEventHandler(eventHandler)
+		if err != nil {
+			return fmt.Errorf("failed to add event handler for %T: %w", obj, err)
+		}
+		registrations = append(registrations, registration.HasSynced)
+	}
+
+	if !toolscache.WaitForCacheSync(ctx.Done(), registrations...) {
+		return nil
+	}
+	h.watching.Store(true)
+	reload()
+	h.synced.Store(true)
+	log.Info("loaded class data from class resources")
+
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection ensures the class data is kept up to date on every
+// replica, so that a replica taking over leadership isn't serving stale data.
+func (h *ResourceHandler) NeedLeaderElection() bool {
+	return false
+}
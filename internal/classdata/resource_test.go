@@ -0,0 +1,62 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	"github.com/jmickey/telegraf-sidecar-operator/api/v1alpha1"
+)
+
+func TestResourceHandler_Update(t *testing.T) {
+	h := NewResourceHandler(nil)
+	if h.HasSynced() {
+		t.Fatalf("expected HasSynced() to be false before the class resources have been loaded")
+	}
+
+	class := &v1alpha1.TelegrafClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "a"},
+		Spec:       v1alpha1.TelegrafClassSpec{Config: testClassA},
+	}
+	nsClass := &v1alpha1.TelegrafNamespaceClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "team"},
+		Spec:       v1alpha1.TelegrafClassSpec{Config: "[operator]\n  extends = [\"a\"]\n"},
+	}
+	h.setClass(class, false)
+	h.setClass(nsClass, false)
+
+	if err := h.Update(); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if data, _ := h.GetDataForClass("a"); string(data) != testClassA {
+		t.Errorf("class a = %q, want %q", data, testClassA)
+	}
+	if data, ok := h.GetDataForClass("team/b"); !ok || len(Ancestors(data)) != 1 {
+		t.Errorf("expected namespaced class team/b to extend class a, got %q", data)
+	}
+
+	h.setClass(toolscache.DeletedFinalStateUnknown{Key: "team/b", Obj: nsClass}, true)
+	if err := h.Update(); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, ok := h.GetDataForClass("team/b"); ok {
+		t.Errorf("expected deleted namespaced class team/b to be removed")
+	}
+}
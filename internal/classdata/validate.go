@@ -0,0 +1,139 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// sidecarEnvVars are the environment variables a telegraf sidecar can have: the
+// ones set by the injector webhook, and HOSTNAME set by the container runtime.
+// The variables added through the env annotations of a pod are named by the
+// pod, and are allowed with WithEnvVars.
+var sidecarEnvVars = []string{
+	"HOSTNAME",
+	metadata.PodNameEnvVar,
+	metadata.NodeNameEnvVar,
+	metadata.NamespaceEnvVar,
+	metadata.ScrapeUsernameEnvVar,
+	metadata.ScrapePasswordEnvVar,
+}
+
+// envVarPattern matches the environment variable references telegraf replaces
+// in its configuration: $VAR, ${VAR} and ${VAR} with a default or error
+// message, e.g. ${VAR:-default}. An escaped $$ is matched so it can be skipped.
+var envVarPattern = regexp.MustCompile(`\$(\$|\{([A-Za-z_][A-Za-z0-9_]*)(:?[-?][^}]*)?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// validateClass checks that a resolved class defines an output plugin, only
+// uses known plugins, and only references environment variables the sidecar has.
+func (l *loader) validateClass(data []byte) error {
+	table := make(map[string]any)
+	if _, err := toml.Decode(string(data), &table); err != nil {
+		return fmt.Errorf("failed to decode class: %w", err)
+	}
+
+	var errs error
+	if outputs, _ := table["outputs"].(map[string]any); len(outputs) == 0 {
+		errs = multierr.Append(errs, fmt.Errorf("class does not define any output plugins"))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(table)) {
+		pluginType := key
+		switch key {
+		case "agent", "global_tags", operatorTable:
+			continue
+		case "inputs", "outputs", "processors", "aggregators", "secretstores":
+		default:
+			// Telegraf treats any other top level table as an input plugin,
+			// for compatibility with legacy configuration files.
+			if _, ok := table[key].(map[string]any); !ok {
+				continue
+			}
+			errs = multierr.Append(errs, l.checkPlugin("inputs", key))
+			continue
+		}
+
+		plugins, ok := table[key].(map[string]any)
+		if !ok {
+			errs = multierr.Append(errs, fmt.Errorf("%s must be a table", key))
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(plugins)) {
+			errs = multierr.Append(errs, l.checkPlugin(pluginType, name))
+		}
+	}
+
+	for _, name := range sets.List(envVarReferences(table)) {
+		if !l.envVars.Has(name) {
+			errs = multierr.Append(errs, fmt.Errorf("reference to unknown environment variable: %s", name))
+		}
+	}
+
+	return errs
+}
+
+func (l *loader) checkPlugin(pluginType, name string) error {
+	if !l.plugins.Has(pluginType + "." + name) {
+		return fmt.Errorf("unknown %s plugin: %s", strings.TrimSuffix(pluginType, "s"), name)
+	}
+
+	return nil
+}
+
+// envVarReferences returns the environment variables referenced by the string
+// values of value, without the references that define a default value.
+func envVarReferences(value any) sets.Set[string] {
+	refs := sets.New[string]()
+
+	switch v := value.(type) {
+	case string:
+		for _, match := range envVarPattern.FindAllStringSubmatch(v, -1) {
+			switch {
+			case match[1] == "$":
+			case match[2] != "":
+				if !strings.HasPrefix(strings.TrimPrefix(match[3], ":"), "-") {
+					refs.Insert(match[2])
+				}
+			default:
+				refs.Insert(match[4])
+			}
+		}
+	case map[string]any:
+		for _, elem := range v {
+			refs = refs.Union(envVarReferences(elem))
+		}
+	case []map[string]any:
+		for _, elem := range v {
+			refs = refs.Union(envVarReferences(elem))
+		}
+	case []any:
+		for _, elem := range v {
+			refs = refs.Union(envVarReferences(elem))
+		}
+	}
+
+	return refs
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classdata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
+)
+
+func TestLoader_ValidateClass(t *testing.T) {
+	tests := []struct {
+		name     string
+		class    string
+		opts     []Option
+		wantErrs []string
+	}{
+		{
+			name: "valid class",
+			class: `
+[agent]
+  hostname = "$NODENAME"
+[[inputs.cpu]]
+[[outputs.influxdb_v2]]
+  urls = ["http://influxdb:8086"]
+  token = "${INFLUX_TOKEN:-none}"
+  bucket = "$$literal"
+[global_tags]
+  pod = "${PODNAME}"
+`,
+		},
+		{
+			name:  "scrape auth environment variables",
+			class: "[[outputs.http]]\n  username = \"${TELEGRAF_SCRAPE_USERNAME}\"\n  password = \"${TELEGRAF_SCRAPE_PASSWORD}\"\n",
+		},
+		{
+			name:     "missing outputs",
+			class:    "[[inputs.cpu]]\n",
+			wantErrs: []string{"class does not define any output plugins"},
+		},
+		{
+			name:  "unknown plugins",
+			class: "[[inputs.cpuu]]\n[[outputs.file]]\n[[processors.custom]]\n",
+			wantErrs: []string{
+				"unknown input plugin: cpuu",
+				"unknown processor plugin: custom",
+			},
+		},
+		{
+			name:  "additional plugins",
+			class: "[[outputs.file]]\n[[processors.custom]]\n",
+			opts:  []Option{WithPlugins("processors.custom")},
+		},
+		{
+			name:     "legacy input table",
+			class:    "[[outputs.file]]\n[cpuu]\n",
+			wantErrs: []string{"unknown input plugin: cpuu"},
+		},
+		{
+			name:  "unknown environment variables",
+			class: "[[outputs.http]]\n  url = \"$URL\"\n  headers = { Authorization = \"Bearer ${TOKEN:?token required}\" }\n",
+			wantErrs: []string{
+				"reference to unknown environment variable: TOKEN",
+				"reference to unknown environment variable: URL",
+			},
+		},
+		{
+			name:  "additional environment variables",
+			class: "[[outputs.http]]\n  url = \"$URL\"\n",
+			opts:  []Option{WithEnvVars("URL")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newLoader(tt.opts...).validateClass([]byte(tt.class))
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("validateClass() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateClass() error = nil, want %v", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validateClass() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoader_Prepare(t *testing.T) {
+	if err := featuregate.Set("telegraf.classvalidation", true); err != nil {
+		t.Fatalf("failed to enable feature gate: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := featuregate.Set("telegraf.classvalidation", false); err != nil {
+			t.Errorf("failed to disable feature gate: %v", err)
+		}
+	})
+
+	l := newLoader()
+	previous := map[string][]byte{"a": []byte(testClassA)}
+	data := map[string][]byte{
+		"a":    []byte("[[inputs.cpu]]\n"),
+		"b":    []byte(testClassB),
+		"c":    []byte("[[outputs.unknown]]\n"),
+		"base": []byte("[operator]\n  abstract = true\n[global_tags]\n  region = \"eu\"\n"),
+		"d":    []byte("[operator]\n  extends = [\"base\"]\n" + testClassB),
+	}
+
+	got, err := l.prepare(data, nil, previous)
+	if err == nil {
+		t.Fatalf("expected prepare() to fail with invalid classes")
+	}
+	for _, want := range []string{"invalid class: a", "invalid class: c"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("prepare() error = %v, want %q", err, want)
+		}
+	}
+
+	if string(got["a"]) != testClassA {
+		t.Errorf("class a = %q, want previous version %q", got["a"], testClassA)
+	}
+	if string(got["b"]) != testClassB {
+		t.Errorf("class b = %q, want %q", got["b"], testClassB)
+	}
+	for _, name := range []string{"c", "base"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("expected class %q to be left out", name)
+		}
+	}
+	if _, ok := got["d"]; !ok {
+		t.Errorf("expected class d extending an abstract class to be valid")
+	}
+}
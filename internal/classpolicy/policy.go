@@ -0,0 +1,223 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classpolicy
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+)
+
+// Action is what happens to a pod requesting a class its namespace isn't
+// allowed to use.
+type Action string
+
+const (
+	// ActionReject rejects the pod in the webhook, and fails rendering the
+	// configuration of pods that weren't rejected.
+	ActionReject Action = "reject"
+	// ActionFallback renders the configuration of the pod with the default class.
+	ActionFallback Action = "fallback"
+)
+
+// Policy restricts which namespaces are allowed to use a class, and selects
+// the class of pods that don't request one. Classes that aren't listed in the
+// policy can be used by every namespace.
+type Policy struct {
+	Action  Action          `json:"action,omitempty"`
+	Classes []ClassAccess   `json:"classes,omitempty"`
+	Rules   []SelectionRule `json:"rules,omitempty"`
+
+	selectors map[string][]labels.Selector
+	rules     []rule
+}
+
+// ClassAccess allows the namespaces matching NamespaceSelector to use the class.
+// A class listed more than once can be used by the namespaces matching any of
+// the selectors.
+type ClassAccess struct {
+	Name              string                `json:"name"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// SelectionRule selects Class for pods without a class annotation. A pod
+// matches the rule if it matches all of the selectors that are set, and its
+// controlling owner is of one of the OwnerKinds, if any.
+type SelectionRule struct {
+	Class             string                `json:"class"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+	OwnerKinds        []string              `json:"ownerKinds,omitempty"`
+}
+
+type rule struct {
+	class             string
+	namespaceSelector labels.Selector
+	podSelector       labels.Selector
+	ownerKinds        []string
+}
+
+// Load reads a Policy from the YAML or JSON file at path.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read class policy file: %s, error: %w", path, err)
+	}
+
+	return Parse(data)
+}
+
+// Parse returns the Policy defined by the YAML or JSON data.
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse class policy: %w", err)
+	}
+
+	switch p.Action {
+	case "":
+		p.Action = ActionReject
+	case ActionReject, ActionFallback:
+	default:
+		return nil, fmt.Errorf("invalid class policy action: %s, valid values: [ %s, %s ]",
+			p.Action, ActionReject, ActionFallback)
+	}
+
+	p.selectors = make(map[string][]labels.Selector)
+	for i, class := range p.Classes {
+		if class.Name == "" {
+			return nil, fmt.Errorf("class policy entry: %d is missing the class name", i)
+		}
+
+		// A missing selector matches no namespace, which makes the class
+		// unavailable unless another entry allows it.
+		selector, err := metav1.LabelSelectorAsSelector(class.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector for class: %s, error: %w", class.Name, err)
+		}
+		p.selectors[class.Name] = append(p.selectors[class.Name], selector)
+	}
+
+	for i, r := range p.Rules {
+		if r.Class == "" {
+			return nil, fmt.Errorf("class selection rule: %d is missing the class name", i)
+		}
+
+		namespaceSelector, err := ruleSelector(r.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector for class selection rule: %d, error: %w", i, err)
+		}
+		podSelector, err := ruleSelector(r.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod selector for class selection rule: %d, error: %w", i, err)
+		}
+
+		p.rules = append(p.rules, rule{
+			class:             r.Class,
+			namespaceSelector: namespaceSelector,
+			podSelector:       podSelector,
+			ownerKinds:        r.OwnerKinds,
+		})
+	}
+
+	return p, nil
+}
+
+// ruleSelector converts a selection rule selector, where a missing selector
+// matches everything.
+func ruleSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// Allowed returns whether pods in namespace are allowed to use class.
+func (p *Policy) Allowed(class string, namespace *corev1.Namespace) bool {
+	selectors, ok := p.selectors[class]
+	if !ok {
+		return true
+	}
+
+	for _, selector := range selectors {
+		if selector.Matches(labels.Set(namespace.GetLabels())) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Denied returns the classes that pods in namespace aren't allowed to use out
+// of a requested class, the class that it is the canary revision of, and the
+// cluster classes that data, the data the class is rendered with, inherits from.
+// Otherwise a class could be used through a canary revision, or a class
+// extending it.
+func (p *Policy) Denied(class string, data []byte, namespace *corev1.Namespace) []string {
+	classes := []string{class}
+	for _, ancestor := range classdata.Ancestors(data) {
+		// The namespaced classes of the namespace itself are always allowed,
+		// the cluster classes they inherit from are in the ancestors as well.
+		if !strings.Contains(ancestor, "/") {
+			classes = append(classes, ancestor)
+		}
+	}
+
+	var denied []string
+	for _, name := range classes {
+		base := strings.TrimSuffix(name, classdata.CanarySuffix)
+		for _, name := range []string{name, base} {
+			if !p.Allowed(name, namespace) && !slices.Contains(denied, name) {
+				denied = append(denied, name)
+			}
+		}
+	}
+
+	return denied
+}
+
+// SelectClass returns the class of the first selection rule matching the pod,
+// and false if none of the rules match.
+func (p *Policy) SelectClass(pod *corev1.Pod, namespace *corev1.Namespace) (string, bool) {
+	var ownerKind string
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		ownerKind = owner.Kind
+	}
+
+	for _, r := range p.rules {
+		if !r.namespaceSelector.Matches(labels.Set(namespace.GetLabels())) ||
+			!r.podSelector.Matches(labels.Set(pod.GetLabels())) {
+			continue
+		}
+		if len(r.ownerKinds) > 0 && !slices.Contains(r.ownerKinds, ownerKind) {
+			continue
+		}
+
+		return r.class, true
+	}
+
+	return "", false
+}
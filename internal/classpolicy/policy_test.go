@@ -0,0 +1,306 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package classpolicy
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testPolicy = `
+action: fallback
+classes:
+  - name: prod-billing
+    namespaceSelector:
+      matchLabels:
+        team: billing
+  - name: prod-billing
+    namespaceSelector:
+      matchExpressions:
+        - key: kubernetes.io/metadata.name
+          operator: In
+          values: ["finance"]
+  - name: disabled
+`
+
+func testNamespace(name string, labels map[string]string) *corev1.Namespace {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels["kubernetes.io/metadata.name"] = name
+
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantAction Action
+		wantErr    bool
+	}{
+		{
+			name:       "valid policy",
+			data:       testPolicy,
+			wantAction: ActionFallback,
+		},
+		{
+			name:       "action defaults to reject",
+			data:       "classes: []",
+			wantAction: ActionReject,
+		},
+		{
+			name:    "invalid action",
+			data:    "action: ignore",
+			wantErr: true,
+		},
+		{
+			name:    "missing class name",
+			data:    "classes:\n  - namespaceSelector: {}",
+			wantErr: true,
+		},
+		{
+			name:    "invalid selector",
+			data:    "classes:\n  - name: a\n    namespaceSelector:\n      matchExpressions:\n        - key: a\n          operator: Bogus",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			data:    "class: []",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if p.Action != tt.wantAction {
+				t.Errorf("Parse() action = %s, want %s", p.Action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestPolicy_Allowed(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		class     string
+		namespace *corev1.Namespace
+		want      bool
+	}{
+		{
+			name:      "unrestricted class",
+			class:     "default",
+			namespace: testNamespace("payments", nil),
+			want:      true,
+		},
+		{
+			name:      "matching label selector",
+			class:     "prod-billing",
+			namespace: testNamespace("billing", map[string]string{"team": "billing"}),
+			want:      true,
+		},
+		{
+			name:      "matching second entry",
+			class:     "prod-billing",
+			namespace: testNamespace("finance", nil),
+			want:      true,
+		},
+		{
+			name:      "not matching",
+			class:     "prod-billing",
+			namespace: testNamespace("payments", map[string]string{"team": "payments"}),
+			want:      false,
+		},
+		{
+			name:      "entry without selector",
+			class:     "disabled",
+			namespace: testNamespace("billing", map[string]string{"team": "billing"}),
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Allowed(tt.class, tt.namespace); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Denied(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		class     string
+		data      string
+		namespace *corev1.Namespace
+		want      []string
+	}{
+		{
+			name:      "unrestricted class",
+			class:     "default",
+			data:      "[[outputs.file]]\n  files = [\"stdout\"]\n",
+			namespace: testNamespace("payments", nil),
+		},
+		{
+			name:      "restricted class",
+			class:     "prod-billing",
+			namespace: testNamespace("payments", nil),
+			want:      []string{"prod-billing"},
+		},
+		{
+			name:      "canary revision of a restricted class",
+			class:     "prod-billing.canary",
+			data:      "[operator]\n  canary = 10\n",
+			namespace: testNamespace("payments", nil),
+			want:      []string{"prod-billing"},
+		},
+		{
+			name:      "canary revision in an allowed namespace",
+			class:     "prod-billing.canary",
+			data:      "[operator]\n  canary = 10\n",
+			namespace: testNamespace("finance", nil),
+		},
+		{
+			name:      "class extending a restricted class",
+			class:     "team",
+			data:      "[operator]\n  ancestors = [\"base\", \"prod-billing\"]\n",
+			namespace: testNamespace("payments", nil),
+			want:      []string{"prod-billing"},
+		},
+		{
+			name:      "namespaced class extending a restricted cluster class",
+			class:     "app",
+			data:      "[operator]\n  ancestors = [\"payments/base\", \"disabled\"]\n",
+			namespace: testNamespace("payments", nil),
+			want:      []string{"disabled"},
+		},
+		{
+			name:      "class extending a restricted class in an allowed namespace",
+			class:     "team",
+			data:      "[operator]\n  ancestors = [\"prod-billing\"]\n",
+			namespace: testNamespace("billing", map[string]string{"team": "billing"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Denied(tt.class, []byte(tt.data), tt.namespace); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Denied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_SelectClass(t *testing.T) {
+	p, err := Parse([]byte(`
+rules:
+  - class: prod-statefulsets
+    namespaceSelector:
+      matchLabels:
+        env: prod
+    ownerKinds: ["StatefulSet"]
+  - class: prod
+    namespaceSelector:
+      matchLabels:
+        env: prod
+  - class: debug
+    podSelector:
+      matchLabels:
+        debug: "true"
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	controller := true
+	statefulSetPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "db-0",
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "StatefulSet", Name: "db", Controller: &controller},
+		},
+	}}
+	debugPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   "app",
+		Labels: map[string]string{"debug": "true"},
+	}}
+
+	tests := []struct {
+		name      string
+		pod       *corev1.Pod
+		namespace *corev1.Namespace
+		want      string
+		wantOk    bool
+	}{
+		{
+			name:      "first matching rule wins",
+			pod:       statefulSetPod,
+			namespace: testNamespace("payments", map[string]string{"env": "prod"}),
+			want:      "prod-statefulsets",
+			wantOk:    true,
+		},
+		{
+			name:      "owner kind not matching",
+			pod:       debugPod,
+			namespace: testNamespace("payments", map[string]string{"env": "prod"}),
+			want:      "prod",
+			wantOk:    true,
+		},
+		{
+			name:      "pod selector",
+			pod:       debugPod,
+			namespace: testNamespace("payments", nil),
+			want:      "debug",
+			wantOk:    true,
+		},
+		{
+			name:      "no matching rule",
+			pod:       statefulSetPod,
+			namespace: testNamespace("payments", nil),
+			wantOk:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.SelectClass(tt.pod, tt.namespace)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("SelectClass() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
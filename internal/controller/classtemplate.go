@@ -0,0 +1,124 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// classTemplateData is the data class templates are executed with.
+type classTemplateData struct {
+	Pod   classTemplatePod
+	Owner classTemplateOwner
+}
+
+type classTemplatePod struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// classTemplateOwner is the controlling owner of the pod, e.g. its ReplicaSet
+// or StatefulSet. Both fields are empty for pods without a controller.
+type classTemplateOwner struct {
+	Kind string
+	Name string
+}
+
+// newClassTemplateData returns the template data for pod. Every value is
+// escaped for a TOML basic string, as labels and annotations are set by the pod
+// owner and must not be able to close the string and add settings or plugins
+// to the configuration.
+func newClassTemplateData(pod *corev1.Pod) classTemplateData {
+	data := classTemplateData{
+		Pod: classTemplatePod{
+			Name:        escapeTOMLString(pod.GetName()),
+			Namespace:   escapeTOMLString(pod.GetNamespace()),
+			Labels:      escapeTOMLStrings(pod.GetLabels()),
+			Annotations: escapeTOMLStrings(pod.GetAnnotations()),
+		},
+	}
+
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		data.Owner = classTemplateOwner{
+			Kind: escapeTOMLString(owner.Kind),
+			Name: escapeTOMLString(owner.Name),
+		}
+	}
+
+	return data
+}
+
+func escapeTOMLStrings(values map[string]string) map[string]string {
+	escaped := make(map[string]string, len(values))
+	for key, value := range values {
+		escaped[key] = escapeTOMLString(value)
+	}
+
+	return escaped
+}
+
+// escapeTOMLString escapes s to be placed within a TOML basic string.
+func escapeTOMLString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// renderClassTemplate executes the class data as a Go template. Referencing a
+// field or map key that doesn't exist is an error, rather than silently
+// rendering "<no value>" into the configuration.
+func renderClassTemplate(class string, classData []byte, data classTemplateData) ([]byte, error) {
+	tmpl, err := template.New(class).Option("missingkey=error").Parse(string(classData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse class data: %s as template, error: %w", class, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render class data: %s, error: %w", class, err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+)
+
+const (
+	metricsNamespace = "telegraf_sidecar_operator"
+
+	collectTimeout = 10 * time.Second
+)
+
+var outdatedConfigsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "outdated_configs"),
+	"Number of telegraf config secrets rendered from a class revision that is no longer current.",
+	[]string{"namespace", "class"}, nil,
+)
+
+// outdatedConfigsCollector compares the class hash recorded on each telegraf
+// config secret with the current class data when metrics are scraped.
+type outdatedConfigsCollector struct {
+	reader           client.Reader
+	classDataHandler classdata.Handler
+}
+
+func (c *outdatedConfigsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- outdatedConfigsDesc
+}
+
+func (c *outdatedConfigsCollector) Collect(ch chan<- prometheus.Metric) {
+	// Every config would be reported as outdated until the classes have been
+	// loaded.
+	if !c.classDataHandler.HasSynced() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	secrets := &corev1.SecretList{}
+	if err := c.reader.List(ctx, secrets,
+		client.MatchingLabels{metadata.SecretManagedByLabelKey: metadata.ControllerName},
+		client.HasLabels{metadata.TelegrafSecretClassHashLabel},
+	); err != nil {
+		ch <- prometheus.NewInvalidMetric(outdatedConfigsDesc, err)
+		return
+	}
+
+	type key struct{ namespace, class string }
+	outdated := make(map[key]int)
+	for _, secret := range secrets.Items {
+		classes := secretClasses(&secret)
+		k := key{namespace: secret.GetNamespace(), class: strings.Join(classes, ",")}
+		if _, ok := outdated[k]; !ok {
+			outdated[k] = 0
+		}
+
+		var uid types.UID
+		for _, owner := range secret.GetOwnerReferences() {
+			if owner.Kind == "Pod" {
+				uid = owner.UID
+			}
+		}
+
+		// The current class data is looked up in the same way as when the
+		// configuration is rendered, including the canary revisions.
+		classesData := make([][]byte, 0, len(classes))
+		for _, class := range classes {
+			data, _, ok := lookupClass(c.classDataHandler, secret.GetNamespace(), uid, class)
+			// A class that no longer exists can't be rendered either, the
+			// secret is outdated until the pod is moved to a different class.
+			if !ok {
+				classesData = nil
+				break
+			}
+			classesData = append(classesData, data)
+		}
+		if classesData == nil || contentHash(bytes.Join(classesData, []byte("\n"))) !=
+			secret.GetLabels()[metadata.TelegrafSecretClassHashLabel] {
+			outdated[k]++
+		}
+	}
+
+	for k, count := range outdated {
+		ch <- prometheus.MustNewConstMetric(outdatedConfigsDesc, prometheus.GaugeValue, float64(count), k.namespace, k.class)
+	}
+}
@@ -17,13 +17,22 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
@@ -31,11 +40,42 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classpolicy"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/version"
+)
+
+const (
+	configReadyReasonRendered       = "ConfigRendered"
+	configReadyReasonRenderFailed   = "ConfigRenderFailed"
+	configReadyReasonSecretConflict = "SecretConflict"
+
+	// A pod whose config secret is owned by another pod is retried every
+	// secretConflictRetryInterval, and every secretConflictBackoff once it
+	// has been retried maxSecretConflictRetries times.
+	secretConflictRetryInterval = 5 * time.Second
+	secretConflictBackoff       = time.Minute
+	maxSecretConflictRetries    = 12
+
+	// classDataSyncInterval is how often a pod is retried until the classes
+	// have been loaded.
+	classDataSyncInterval = time.Second
+
+	// secretClassesIndex indexes the telegraf config secrets by the classes
+	// they were rendered from, see secretClassNames.
+	secretClassesIndex = ".metadata.classes"
 )
 
 // PodReconciler reconciles a Pod object
@@ -46,6 +86,21 @@ type PodReconciler struct {
 	ClassDataHandler     classdata.Handler
 	DefaultClass         string
 	EnableInternalPlugin bool
+	// ConfigUpdateRate limits how many pods per second are re-rendered
+	// after a class has changed. Zero disables the limit.
+	ConfigUpdateRate  rate.Limit
+	ConfigUpdateBurst int
+	// ClassPolicy restricts which namespaces can use a class, nil allows
+	// every namespace to use every class.
+	ClassPolicy *classpolicy.Policy
+	// MissingClassPolicy is how the configuration of a pod requesting a class
+	// that doesn't exist is rendered, the empty value fails rendering it.
+	MissingClassPolicy MissingClassPolicy
+	// Monitors translates the PodMonitors and ServiceMonitors selecting the
+	// pods of opted in namespaces into telegraf inputs, nil disables it.
+	Monitors *monitors.Source
+
+	conflicts secretConflicts
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
@@ -53,6 +108,9 @@ type PodReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=pods/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=podmonitors;servicemonitors,verbs=get;list;watch
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -68,7 +126,32 @@ func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return fmt.Errorf("failed to create label selector predicate: %w", err)
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Secret{}, secretClassesIndex,
+		indexSecretClasses); err != nil {
+		return fmt.Errorf("failed to index secrets by class: %w", err)
+	}
+
+	changes := &classChanges{
+		pending: make(map[string]struct{}),
+		signal:  make(chan struct{}, 1),
+	}
+	r.ClassDataHandler.Subscribe(changes.add)
+
+	classEvents := make(chan event.GenericEvent)
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return r.enqueueClassChanges(ctx, changes, classEvents)
+	})); err != nil {
+		return fmt.Errorf("failed to add class change runnable: %w", err)
+	}
+
+	if err := metrics.Registry.Register(&outdatedConfigsCollector{
+		reader:           mgr.GetClient(),
+		classDataHandler: r.ClassDataHandler,
+	}); err != nil {
+		return fmt.Errorf("failed to register outdated configs metric: %w", err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}, builder.WithPredicates(
 			labelPredicate,
 			predicate.Or(
@@ -78,7 +161,173 @@ func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			),
 		)).
 		Owns(&corev1.Secret{}).
-		Complete(r)
+		WatchesRawSource(source.Channel(classEvents, &handler.EnqueueRequestForObject{}))
+
+	// Only the monitor kinds installed when the operator starts are watched,
+	// so that the prometheus-operator CRDs aren't required.
+	if r.Monitors != nil {
+		for _, gvk := range r.Monitors.Kinds() {
+			monitor := &unstructured.Unstructured{}
+			monitor.SetGroupVersionKind(gvk)
+			b = b.Watches(monitor, handler.EnqueueRequestsFromMapFunc(r.podsForMonitor))
+		}
+	}
+
+	return b.Complete(r)
+}
+
+// podsForMonitor maps a PodMonitor or ServiceMonitor to the injected pods it
+// selects. Pods that were selected before the monitor was changed are left
+// with the inputs of the monitor until they are reconciled again.
+func (r *PodReconciler) podsForMonitor(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := logf.FromContext(ctx)
+
+	monitor, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+
+	pods, err := r.Monitors.Pods(ctx, monitor)
+	if err != nil {
+		log.Error(err, "failed to list pods for monitor", "monitor", client.ObjectKeyFromObject(obj))
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(pods))
+	for _, pod := range pods {
+		requests = append(requests, reconcile.Request{NamespacedName: pod})
+	}
+
+	return requests
+}
+
+// classChanges collects the names of changed classes until they are
+// processed, so that class data subscribers are never blocked.
+type classChanges struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	signal  chan struct{}
+}
+
+func (c *classChanges) add(classes []string) {
+	c.mu.Lock()
+	for _, class := range classes {
+		c.pending[class] = struct{}{}
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (c *classChanges) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	classes := make([]string, 0, len(c.pending))
+	for class := range c.pending {
+		classes = append(classes, class)
+	}
+	clear(c.pending)
+
+	return classes
+}
+
+// enqueueClassChanges enqueues every pod with a config secret rendered from a
+// changed class, rate limited so that a class change doesn't result in a
+// burst of secret updates across the cluster.
+func (r *PodReconciler) enqueueClassChanges(ctx context.Context, changes *classChanges,
+	events chan<- event.GenericEvent) error {
+	log := logf.FromContext(ctx).WithName("class-changes")
+
+	limit, burst := r.ConfigUpdateRate, max(r.ConfigUpdateBurst, 1)
+	if limit <= 0 {
+		limit = rate.Inf
+	}
+	limiter := rate.NewLimiter(limit, burst)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes.signal:
+		}
+
+		for _, class := range changes.take() {
+			pods, err := r.podsForClass(ctx, class)
+			if err != nil {
+				log.Error(err, "failed to find pods for changed class", "class", class)
+				continue
+			}
+			// A change to a canary class changes the pods the class is rolled
+			// out to, only the pods that are moved to a different revision get
+			// a new configuration.
+			if base, ok := strings.CutSuffix(class, classdata.CanarySuffix); ok {
+				basePods, err := r.podsForClass(ctx, base)
+				if err != nil {
+					log.Error(err, "failed to find pods for changed class", "class", base)
+					continue
+				}
+				pods = append(pods, basePods...)
+			}
+
+			log.Info("re-rendering telegraf config for pods using changed class", "class", class, "pods", len(pods))
+			for _, pod := range pods {
+				if err := limiter.Wait(ctx); err != nil {
+					return nil
+				}
+				select {
+				case events <- event.GenericEvent{Object: pod}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// podsForClass returns references to all pods with a telegraf config secret
+// rendered from the given class, including the ones using it together with
+// other classes. A namespaced class also applies to the pods in its namespace
+// that were rendered from the cluster class of the same name, or without the
+// class when it was missing.
+func (r *PodReconciler) podsForClass(ctx context.Context, class string) ([]*corev1.Pod, error) {
+	names := []string{class}
+	var opts []client.ListOption
+	if namespace, name, ok := strings.Cut(class, "/"); ok {
+		names = append(names, name)
+		opts = append(opts, client.InNamespace(namespace))
+	}
+
+	var pods []*corev1.Pod
+	for _, name := range names {
+		secrets := &corev1.SecretList{}
+		if err := r.List(ctx, secrets, append(opts, client.MatchingFields{secretClassesIndex: name})...); err != nil {
+			return nil, fmt.Errorf("failed to list secrets for class: %s, error: %w", class, err)
+		}
+
+		for _, secret := range secrets.Items {
+			name := secret.GetLabels()[metadata.TelegrafSecretPodLabel]
+			for _, owner := range secret.GetOwnerReferences() {
+				if owner.Kind == "Pod" {
+					name = owner.Name
+				}
+			}
+			if name == "" || slices.ContainsFunc(pods, func(pod *corev1.Pod) bool {
+				return pod.GetName() == name && pod.GetNamespace() == secret.GetNamespace()
+			}) {
+				continue
+			}
+
+			pods = append(pods, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: secret.GetNamespace()},
+			})
+		}
+	}
+
+	return pods, nil
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -92,6 +341,7 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	obj := &corev1.Pod{}
 	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
 		if apierrors.IsNotFound(err) {
+			r.conflicts.reset(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "failed to fetch pod")
@@ -103,6 +353,14 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, nil
 	}
 
+	// Until the classes have been loaded every class is missing, and the
+	// configuration of running pods would be replaced by the missing class
+	// policy.
+	if !r.ClassDataHandler.HasSynced() {
+		log.V(1).Info("classes haven't been loaded yet, requeueing")
+		return ctrl.Result{RequeueAfter: classDataSyncInterval}, nil
+	}
+
 	secret := &corev1.Secret{}
 	name := types.NamespacedName{
 		Name:      obj.GetLabels()[metadata.SidecarSecretNameLabel],
@@ -116,48 +374,625 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	if err == nil {
 		for _, owner := range secret.GetOwnerReferences() {
 			if owner.UID == obj.GetUID() {
-				log.Info("reconciliation skipped, telegraf-config secret for pod already exists")
-				return ctrl.Result{}, nil
+				r.conflicts.reset(req.NamespacedName)
+				return r.reconcileExisting(ctx, obj, secret)
 			}
 		}
-		// The secret already exists but is not owned by this pod's UID
-		// therefore it should be safe to assume that the secret likely
-		// hasn't been cleaned up yet, so we requeue the object.
-		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		return r.reconcileSecretConflict(ctx, obj, secret)
 	}
 
+	r.conflicts.reset(req.NamespacedName)
 	return r.reconcile(ctx, obj)
 }
 
-func (r *PodReconciler) reconcile(ctx context.Context, obj *corev1.Pod) (ctrl.Result, error) {
+// secretConflicts counts how many times each pod has been retried because its
+// config secret is owned by another pod.
+type secretConflicts struct {
+	mu      sync.Mutex
+	retries map[types.NamespacedName]int
+}
+
+func (c *secretConflicts) inc(key types.NamespacedName) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.retries == nil {
+		c.retries = make(map[types.NamespacedName]int)
+	}
+	c.retries[key]++
+
+	return c.retries[key]
+}
+
+func (c *secretConflicts) reset(key types.NamespacedName) {
+	c.mu.Lock()
+	delete(c.retries, key)
+	c.mu.Unlock()
+}
+
+// reconcileSecretConflict handles a config secret that already exists, but
+// isn't owned by the pod, e.g. the secret of a previous StatefulSet pod with
+// the same name that hasn't been garbage collected yet. A secret managed by
+// the operator whose owner no longer exists is replaced, otherwise the pod is
+// retried until the secret has been removed.
+func (r *PodReconciler) reconcileSecretConflict(ctx context.Context, obj *corev1.Pod,
+	secret *corev1.Secret) (ctrl.Result, error) {
+	log := logf.FromContext(ctx).WithName("reconcile")
+	key := client.ObjectKeyFromObject(obj)
+
+	if secret.GetLabels()[metadata.SecretManagedByLabelKey] == metadata.ControllerName {
+		owned, err := hasLiveOwner(ctx, r.Client, secret)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !owned {
+			// The precondition ensures that a secret created for this pod in
+			// the meantime isn't deleted.
+			uid := secret.GetUID()
+			if err := r.Delete(ctx, secret, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to delete stale secret: %s, error: %w", secret.GetName(), err)
+			}
+
+			msg := fmt.Sprintf("replacing stale telegraf config secret: %s, the pod owning it no longer exists",
+				secret.GetName())
+			r.Recorder.Event(obj, corev1.EventTypeNormal, "StaleSecretReplaced", msg)
+			log.Info(msg)
+
+			r.conflicts.reset(key)
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	retries := r.conflicts.inc(key)
+	if retries < maxSecretConflictRetries {
+		return ctrl.Result{RequeueAfter: secretConflictRetryInterval}, nil
+	}
+	if retries == maxSecretConflictRetries {
+		msg := fmt.Sprintf("telegraf config secret: %s is owned by another pod, still waiting for it to be "+
+			"removed after %d retries", secret.GetName(), retries)
+		r.Recorder.Event(obj, corev1.EventTypeWarning, "SecretConflict", msg)
+		log.Info(msg)
+
+		// The conflict is set on every pod, rather than only on pods with the
+		// readiness gate, as it is otherwise only visible as a single event.
+		if err := r.patchConfigReadyCondition(ctx, obj, corev1.ConditionFalse, configReadyReasonSecretConflict,
+			msg); err != nil {
+			log.Error(err, "failed to set telegraf config-ready pod condition")
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: secretConflictBackoff}, nil
+}
+
+// renderConfig builds the telegraf configuration for the pod, recording any
+// problems with the pod annotations or the resulting configuration as events.
+func (r *PodReconciler) renderConfig(ctx context.Context, obj *corev1.Pod) (*annotationValues, string, error) {
 	log := logf.FromContext(ctx).WithName("reconcile")
 
-	telegrafConfig := newAnnotationValues(r.ClassDataHandler, r.DefaultClass, r.EnableInternalPlugin)
+	// The default class of the pod is selected by the class policy rules, and
+	// is overridden by the class annotation.
+	defaultClass := r.DefaultClass
+	var namespace *corev1.Namespace
+	if r.ClassPolicy != nil || r.Monitors != nil {
+		namespace = &corev1.Namespace{}
+		if err := r.Get(ctx, types.NamespacedName{Name: obj.GetNamespace()}, namespace); err != nil {
+			return nil, "", fmt.Errorf("failed to get namespace: %s, error: %w", obj.GetNamespace(), err)
+		}
+	}
+	if r.ClassPolicy != nil {
+		if class, ok := r.ClassPolicy.SelectClass(obj, namespace); ok {
+			log.V(1).Info("class selected by class policy rules", "class", class)
+			defaultClass = class
+		}
+	}
+
+	telegrafConfig := newAnnotationValues(r.ClassDataHandler, obj, defaultClass, r.EnableInternalPlugin)
 	if err := telegrafConfig.applyAnnotationOverrides(obj.GetAnnotations()); err != nil {
 		msg := fmt.Sprintf("one or more warnings were generated when applying telegraf pod annotations: [ %s ]", err.Error())
 		r.Recorder.Event(obj, corev1.EventTypeWarning, "InvalidAnnotationFormat", msg)
 		log.Info(msg)
 	}
 
+	if err := r.applyClassPolicy(ctx, obj, namespace, telegrafConfig, defaultClass); err != nil {
+		return nil, "", &renderError{err: err}
+	}
+
+	if r.Monitors != nil && monitors.Enabled(namespace) {
+		targets, warnings, err := r.Monitors.Targets(ctx, obj)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get monitor targets for pod: %s, error: %w", obj.GetName(), err)
+		}
+		if len(warnings) > 0 {
+			msg := fmt.Sprintf("monitor settings that can't be translated were ignored: [ %s ]",
+				strings.Join(warnings, "; "))
+			r.Recorder.Event(obj, corev1.EventTypeWarning, "UnsupportedMonitorSettings", msg)
+			log.Info(msg)
+		}
+		telegrafConfig.monitorTargets = targets
+	}
+
+	telegrafConfig.missingClassPolicy = r.MissingClassPolicy
+	telegrafConfig.fallbackClass = defaultClass
 	configData, err := telegrafConfig.buildConfigData()
+	if recordErr := r.recordMissingClasses(ctx, obj, telegrafConfig, err != nil); recordErr != nil {
+		if err == nil {
+			return nil, "", recordErr
+		}
+		log.Error(recordErr, "failed to record missing classes on pod")
+	}
 	if err != nil {
 		msg := fmt.Sprintf("error building telegraf config: %s", err.Error())
 		r.Recorder.Event(obj, corev1.EventTypeWarning, "InvalidTelegrafConfiguration", msg)
 		log.Error(err, "error building telegraf config")
 
-		return ctrl.Result{}, fmt.Errorf("error building telegraf configuration: %w", err)
+		return nil, "", &renderError{err: fmt.Errorf("error building telegraf configuration: %w", err)}
+	}
+
+	if len(telegrafConfig.agentConflicts) > 0 {
+		msg := fmt.Sprintf("classes: %s set conflicting agent settings, the last class wins: [ %s ]",
+			telegrafConfig.class, strings.Join(telegrafConfig.agentConflicts, "; "))
+		r.Recorder.Event(obj, corev1.EventTypeWarning, "ConflictingAgentSettings", msg)
+		log.Info(msg)
+	}
+
+	return telegrafConfig, configData, nil
+}
+
+// renderError is returned by renderConfig if the classes and annotations of a
+// pod can't be rendered, rather than a request to the API server failing.
+type renderError struct {
+	err error
+}
+
+func (e *renderError) Error() string {
+	return e.err.Error()
+}
+
+func (e *renderError) Unwrap() error {
+	return e.err
+}
+
+// recordMissingClasses records how the requested classes that don't exist were
+// rendered in the class-fallback annotation of the pod, which is removed once
+// all of the classes exist.
+func (r *PodReconciler) recordMissingClasses(ctx context.Context, obj *corev1.Pod,
+	telegrafConfig *annotationValues, failed bool) error {
+	var fallback string
+	if missing := telegrafConfig.missingClasses; len(missing) > 0 {
+		policy := telegrafConfig.missingClassPolicy
+		if failed {
+			policy = MissingClassPolicyFail
+		}
+
+		msg := fmt.Sprintf("class: %s doesn't exist", strings.Join(missing, ","))
+		switch policy {
+		case MissingClassPolicyDefault:
+			msg = fmt.Sprintf("%s, rendered with default class: %s", msg, telegrafConfig.fallbackClass)
+		case MissingClassPolicyMinimal:
+			msg = fmt.Sprintf("%s, rendered with a minimal configuration writing metrics to stdout", msg)
+		}
+		r.Recorder.Event(obj, corev1.EventTypeWarning, "ClassNotFound", msg)
+		logf.FromContext(ctx).WithName("reconcile").Info(msg)
+
+		fallback = string(policy)
+	}
+
+	current, ok := obj.GetAnnotations()[metadata.ClassFallbackAnnotation]
+	if current == fallback && ok == (fallback != "") {
+		return nil
+	}
+
+	orig := obj.DeepCopy()
+	if fallback == "" {
+		delete(obj.Annotations, metadata.ClassFallbackAnnotation)
+	} else {
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
+		}
+		obj.Annotations[metadata.ClassFallbackAnnotation] = fallback
+	}
+
+	if err := r.Patch(ctx, obj, client.MergeFrom(orig)); err != nil {
+		return fmt.Errorf("failed to set annotation: %s on pod: %s, error: %w",
+			metadata.ClassFallbackAnnotation, obj.GetName(), err)
+	}
+
+	return nil
+}
+
+// applyClassPolicy checks that the namespace of the pod is allowed to use all
+// of the requested classes, and the classes they are rendered from, falling
+// back to the default class of the pod if the policy allows it.
+func (r *PodReconciler) applyClassPolicy(ctx context.Context, obj *corev1.Pod, namespace *corev1.Namespace,
+	telegrafConfig *annotationValues, defaultClass string) error {
+	if r.ClassPolicy == nil {
+		return nil
+	}
+	log := logf.FromContext(ctx).WithName("reconcile")
+
+	var denied []string
+	for _, class := range metadata.SplitClasses(telegrafConfig.class) {
+		for _, name := range r.deniedClasses(obj, namespace, class) {
+			if !slices.Contains(denied, name) {
+				denied = append(denied, name)
+			}
+		}
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("class: %s is not allowed in namespace: %s", strings.Join(denied, ","), obj.GetNamespace())
+	if r.ClassPolicy.Action == classpolicy.ActionFallback && len(r.deniedClasses(obj, namespace, defaultClass)) == 0 {
+		msg = fmt.Sprintf("%s, falling back to default class: %s", msg, defaultClass)
+		r.Recorder.Event(obj, corev1.EventTypeWarning, "ClassNotAllowed", msg)
+		log.Info(msg)
+
+		telegrafConfig.class = defaultClass
+		return nil
 	}
 
+	r.Recorder.Event(obj, corev1.EventTypeWarning, "ClassNotAllowed", msg)
+	log.Info(msg)
+
+	return errors.New(msg)
+}
+
+// deniedClasses returns the classes the namespace of the pod isn't allowed to
+// use out of class and the classes that the revision of class rendered for the
+// pod inherits from.
+func (r *PodReconciler) deniedClasses(obj *corev1.Pod, namespace *corev1.Namespace, class string) []string {
+	data, _, _ := lookupClass(r.ClassDataHandler, obj.GetNamespace(), obj.GetUID(), class)
+
+	return r.ClassPolicy.Denied(class, data, namespace)
+}
+
+// reconcileExisting re-renders the telegraf configuration of a pod whose
+// config secret already exists, and updates the secret if the configuration
+// has changed, e.g. because the class has been updated.
+func (r *PodReconciler) reconcileExisting(ctx context.Context, obj *corev1.Pod,
+	secret *corev1.Secret) (ctrl.Result, error) {
+	log := logf.FromContext(ctx).WithName("reconcile")
+
+	telegrafConfig, configData, err := r.renderConfig(ctx, obj)
+	if err != nil {
+		var renderErr *renderError
+		if !errors.As(err, &renderErr) {
+			return ctrl.Result{}, err
+		}
+
+		// The pod is already running with its existing configuration, keep it
+		// rather than retrying until either the class or the pod changes. The
+		// config-ready condition reports that the change wasn't applied.
+		log.Info("keeping existing telegraf config, the configuration of the pod can't be rendered",
+			"secret", secret.GetName(), "error", err.Error())
+		if condErr := r.setConfigReadyCondition(ctx, obj, corev1.ConditionFalse, configReadyReasonRenderFailed,
+			err.Error()); condErr != nil {
+			log.Error(condErr, "failed to set telegraf config-ready pod condition")
+		}
+		return ctrl.Result{}, r.markOutdated(ctx, secret)
+	}
+
+	applied, err := appliedAnnotations(obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	labels, annotations := secretMetadata(telegrafConfig, configData, applied)
+
+	configChanged := !bytes.Equal(secret.Data["telegraf.conf"], []byte(configData))
+	// The operator version is only recorded when the secret is written, an
+	// upgrade of the operator doesn't cause every secret to be updated.
+	if !configChanged && containsAll(secret.GetLabels(), labels) &&
+		containsAll(secret.GetAnnotations(), annotations,
+			metadata.SecretOperatorVersionAnnotation, metadata.SecretOperatorGitCommitAnnotation) &&
+		sameKeys(secret.GetLabels(), labels, optionalSecretLabels...) &&
+		sameKeys(secret.GetAnnotations(), annotations, optionalSecretAnnotations...) {
+		log.V(1).Info("telegraf-config secret for pod is up to date", "secret", secret.GetName())
+		return ctrl.Result{}, r.markRendered(ctx, obj, secret)
+	}
+
+	changes := describeAnnotationChanges(secret.GetAnnotations()[metadata.SecretAppliedAnnotationsAnnotation], applied)
+
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	maps.Copy(secret.Labels, labels)
+	deleteMissing(secret.Labels, labels, optionalSecretLabels...)
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	maps.Copy(secret.Annotations, annotations)
+	deleteMissing(secret.Annotations, annotations, optionalSecretAnnotations...)
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["telegraf.conf"] = []byte(configData)
+
+	if err := r.Update(ctx, secret); err != nil {
+		log.Error(err, "failed to update secret in cluster", "secret", secret.GetName())
+		return ctrl.Result{}, fmt.Errorf("failed to update secret: %s in cluster: %w", secret.GetName(), err)
+	}
+
+	if configChanged {
+		msg := fmt.Sprintf("successfully updated telegraf config secret: %s, class: %s", secret.GetName(), telegrafConfig.class)
+		if telegrafConfig.canary {
+			msg = fmt.Sprintf("%s, revision: %s", msg, metadata.ClassRevisionCanary)
+		}
+		if changes != "" {
+			msg = fmt.Sprintf("%s, pod annotations changed: %s", msg, changes)
+		}
+		r.Recorder.Event(obj, corev1.EventTypeNormal, "TelegrafConfigUpdateSuccessful", msg)
+		log.Info("successfully updated telegraf config secret", "secret", secret.GetName(), "annotationChanges", changes)
+	}
+
+	return ctrl.Result{}, r.markRendered(ctx, obj, secret)
+}
+
+// markOutdated labels a config secret that couldn't be re-rendered, so that the
+// pods running an outdated configuration can be listed by the label. The label
+// is removed once the configuration has been rendered again.
+func (r *PodReconciler) markOutdated(ctx context.Context, secret *corev1.Secret) error {
+	if secret.GetLabels()[metadata.TelegrafSecretOutdatedLabel] == "true" {
+		return nil
+	}
+
+	orig := secret.DeepCopy()
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	secret.Labels[metadata.TelegrafSecretOutdatedLabel] = "true"
+
+	if err := r.Patch(ctx, secret, client.MergeFrom(orig)); err != nil {
+		return fmt.Errorf("failed to set label: %s on secret: %s, error: %w",
+			metadata.TelegrafSecretOutdatedLabel, secret.GetName(), err)
+	}
+
+	return nil
+}
+
+// optionalSecretLabels and optionalSecretAnnotations are only set on the secrets
+// they apply to, so that the secrets rendered before they were introduced aren't
+// all updated. They are removed from a secret once they no longer apply.
+var (
+	optionalSecretLabels = []string{
+		metadata.TelegrafSecretClassRevisionLabel,
+		metadata.TelegrafSecretOutdatedLabel,
+	}
+	optionalSecretAnnotations = []string{
+		metadata.SecretComposedClassesAnnotation,
+		metadata.SecretNamespacedClassesAnnotation,
+	}
+)
+
+// secretMetadata returns the labels and annotations recording how the telegraf
+// configuration of a pod was rendered.
+func secretMetadata(telegrafConfig *annotationValues, configData, applied string) (map[string]string, map[string]string) {
+	// Label values can't contain commas, the secret of a pod using more than
+	// one class is labelled with the first class. The class hash covers all of
+	// the classes, and the classes are looked up by secretClassNames.
+	classes := metadata.SplitClasses(telegrafConfig.class)
+	labels := map[string]string{
+		metadata.TelegrafSecretClassNameLabel: classes[0],
+		metadata.TelegrafSecretClassHashLabel: telegrafConfig.classHash,
+	}
+	if telegrafConfig.canary {
+		labels[metadata.TelegrafSecretClassRevisionLabel] = metadata.ClassRevisionCanary
+	}
+	if len(telegrafConfig.missingClasses) > 0 {
+		labels[metadata.TelegrafSecretOutdatedLabel] = "true"
+	}
+	annotations := map[string]string{
+		metadata.SecretAppliedAnnotationsAnnotation: applied,
+		metadata.SecretAnnotationsHashAnnotation:    contentHash([]byte(applied)),
+		metadata.ConfigHashAnnotation:               contentHash([]byte(configData)),
+		metadata.SecretOperatorVersionAnnotation:    version.Version,
+		metadata.SecretOperatorGitCommitAnnotation:  version.GitCommit,
+	}
+	if len(classes) > 1 {
+		annotations[metadata.SecretComposedClassesAnnotation] = strings.Join(classes, ",")
+	}
+	if len(telegrafConfig.namespacedClasses) > 0 {
+		annotations[metadata.SecretNamespacedClassesAnnotation] = strings.Join(telegrafConfig.namespacedClasses, ",")
+	}
+
+	return labels, annotations
+}
+
+// secretClasses returns the classes the configuration in secret was rendered
+// from, in order.
+func secretClasses(secret *corev1.Secret) []string {
+	if composed, ok := secret.GetAnnotations()[metadata.SecretComposedClassesAnnotation]; ok {
+		return metadata.SplitClasses(composed)
+	}
+	if class, ok := secret.GetLabels()[metadata.TelegrafSecretClassNameLabel]; ok {
+		return []string{class}
+	}
+
+	return nil
+}
+
+// secretClassNames returns the names of the classes the configuration in
+// secret was rendered from, with the namespaced classes named by
+// classdata.NamespacedClassName, as the classes are named in class changes.
+func secretClassNames(secret *corev1.Secret) []string {
+	namespaced := metadata.SplitClasses(secret.GetAnnotations()[metadata.SecretNamespacedClassesAnnotation])
+
+	classes := secretClasses(secret)
+	names := make([]string, 0, len(classes))
+	for _, class := range classes {
+		if slices.Contains(namespaced, class) {
+			class = classdata.NamespacedClassName(secret.GetNamespace(), class)
+		}
+		names = append(names, class)
+	}
+
+	return names
+}
+
+// indexSecretClasses indexes the telegraf config secrets by secretClassNames.
+func indexSecretClasses(obj client.Object) []string {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return nil
+	}
+
+	return secretClassNames(secret)
+}
+
+// containsAll returns whether have contains all entries of want, except for
+// the ignored keys.
+func containsAll(have, want map[string]string, ignore ...string) bool {
+	for key, value := range want {
+		if slices.Contains(ignore, key) {
+			continue
+		}
+		if current, ok := have[key]; !ok || current != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameKeys returns whether have and want either both contain or both lack each
+// of the keys.
+func sameKeys(have, want map[string]string, keys ...string) bool {
+	for _, key := range keys {
+		_, inHave := have[key]
+		_, inWant := want[key]
+		if inHave != inWant {
+			return false
+		}
+	}
+
+	return true
+}
+
+// deleteMissing deletes the keys from have that aren't in want.
+func deleteMissing(have, want map[string]string, keys ...string) {
+	for _, key := range keys {
+		if _, ok := want[key]; !ok {
+			delete(have, key)
+		}
+	}
+}
+
+// markRendered records on the pod that its telegraf configuration has been
+// rendered to secret, by mirroring the configuration hash and setting the
+// config-ready condition.
+func (r *PodReconciler) markRendered(ctx context.Context, obj *corev1.Pod, secret *corev1.Secret) error {
+	configHash := secret.GetAnnotations()[metadata.ConfigHashAnnotation]
+	if obj.GetAnnotations()[metadata.ConfigHashAnnotation] != configHash {
+		orig := obj.DeepCopy()
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
+		}
+		obj.Annotations[metadata.ConfigHashAnnotation] = configHash
+
+		if err := r.Patch(ctx, obj, client.MergeFrom(orig)); err != nil {
+			return fmt.Errorf("failed to set annotation: %s on pod: %s, error: %w",
+				metadata.ConfigHashAnnotation, obj.GetName(), err)
+		}
+	}
+
+	return r.setConfigReadyCondition(ctx, obj, corev1.ConditionTrue, configReadyReasonRendered,
+		fmt.Sprintf("telegraf configuration rendered to secret: %s", secret.GetName()))
+}
+
+// appliedAnnotations returns the telegraf annotations of the pod as a JSON
+// object, with the annotation prefix removed from the keys.
+func appliedAnnotations(pod *corev1.Pod) (string, error) {
+	annotations := metadata.GetAnnotationsWithPrefix(pod.GetAnnotations(), metadata.Prefix+"/")
+	// Annotations set by the operator itself aren't used to render the configuration.
+	delete(annotations, strings.TrimPrefix(metadata.ConfigHashAnnotation, metadata.Prefix+"/"))
+	delete(annotations, strings.TrimPrefix(metadata.ClassFallbackAnnotation, metadata.Prefix+"/"))
+	// The prometheus.io annotations are kept with their prefix, so that they
+	// can't be mistaken for the telegraf annotations.
+	if featuregate.PrometheusAnnotations.IsEnabled() && metadata.PrometheusScrapeEnabled(pod.GetAnnotations()) {
+		for key, value := range pod.GetAnnotations() {
+			if strings.HasPrefix(key, metadata.PrometheusPrefix+"/") {
+				annotations[key] = value
+			}
+		}
+	}
+
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal applied pod annotations: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// describeAnnotationChanges returns a human readable summary of the differences
+// between two sets of applied annotations, as returned by appliedAnnotations.
+func describeAnnotationChanges(previous, current string) string {
+	prev := make(map[string]string)
+	curr := make(map[string]string)
+	// Secrets created before the applied annotations were recorded don't have
+	// a previous value, in which case the changes can't be described.
+	if previous == "" || json.Unmarshal([]byte(previous), &prev) != nil {
+		return ""
+	}
+	if err := json.Unmarshal([]byte(current), &curr); err != nil {
+		return ""
+	}
+
+	var added, changed, removed []string
+	for _, key := range slices.Sorted(maps.Keys(curr)) {
+		if prevValue, ok := prev[key]; !ok {
+			added = append(added, key)
+		} else if prevValue != curr[key] {
+			changed = append(changed, key)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(prev)) {
+		if _, ok := curr[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, fmt.Sprintf("added [ %s ]", strings.Join(added, ", ")))
+	}
+	if len(changed) > 0 {
+		parts = append(parts, fmt.Sprintf("changed [ %s ]", strings.Join(changed, ", ")))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed [ %s ]", strings.Join(removed, ", ")))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func (r *PodReconciler) reconcile(ctx context.Context, obj *corev1.Pod) (ctrl.Result, error) {
+	log := logf.FromContext(ctx).WithName("reconcile")
+
+	telegrafConfig, configData, err := r.renderConfig(ctx, obj)
+	if err != nil {
+		if condErr := r.setConfigReadyCondition(ctx, obj, corev1.ConditionFalse, configReadyReasonRenderFailed,
+			err.Error()); condErr != nil {
+			log.Error(condErr, "failed to set telegraf config-ready pod condition")
+		}
+		return ctrl.Result{}, err
+	}
+
+	applied, err := appliedAnnotations(obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	labels, annotations := secretMetadata(telegrafConfig, configData, applied)
+	labels[metadata.TelegrafSecretPodLabel] = obj.GetName()
+	labels[metadata.SecretManagedByLabelKey] = metadata.ControllerName
+	labels[metadata.SecretCreatedByLabelKey] = metadata.ControllerName
+
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      obj.GetLabels()[metadata.SidecarSecretNameLabel],
-			Namespace: obj.GetNamespace(),
-			Labels: map[string]string{
-				metadata.TelegrafSecretClassNameLabel: telegrafConfig.class,
-				metadata.TelegrafSecretPodLabel:       obj.GetName(),
-				metadata.SecretManagedByLabelKey:      metadata.ControllerName,
-				metadata.SecretCreatedByLabelKey:      metadata.ControllerName,
-			},
+			Name:        obj.GetLabels()[metadata.SidecarSecretNameLabel],
+			Namespace:   obj.GetNamespace(),
+			Annotations: annotations,
+			Labels:      labels,
 		},
 		Type: "Opaque",
 		StringData: map[string]string{
@@ -187,7 +1022,65 @@ func (r *PodReconciler) reconcile(ctx context.Context, obj *corev1.Pod) (ctrl.Re
 	r.Recorder.Event(obj, corev1.EventTypeNormal, "TelegrafConfigCreateSuccessful", msg)
 	log.Info("successfully created telegraf config secret", "secret", secret.GetName())
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{}, r.markRendered(ctx, obj, secret)
+}
+
+// setConfigReadyCondition records the state of the telegraf configuration in the
+// config-ready condition of the pod. Pods without the matching readiness gate are
+// left untouched, unless the condition has already been set on them by
+// reconcileSecretConflict.
+func (r *PodReconciler) setConfigReadyCondition(ctx context.Context, pod *corev1.Pod,
+	status corev1.ConditionStatus, reason, message string) error {
+	if !slices.ContainsFunc(pod.Spec.ReadinessGates, func(gate corev1.PodReadinessGate) bool {
+		return gate.ConditionType == metadata.ConfigReadyConditionType
+	}) && !slices.ContainsFunc(pod.Status.Conditions, func(c corev1.PodCondition) bool {
+		return c.Type == metadata.ConfigReadyConditionType
+	}) {
+		return nil
+	}
+
+	return r.patchConfigReadyCondition(ctx, pod, status, reason, message)
+}
+
+// patchConfigReadyCondition sets the config-ready condition of the pod.
+func (r *PodReconciler) patchConfigReadyCondition(ctx context.Context, pod *corev1.Pod,
+	status corev1.ConditionStatus, reason, message string) error {
+	condition := corev1.PodCondition{
+		Type:               metadata.ConfigReadyConditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	idx := slices.IndexFunc(pod.Status.Conditions, func(c corev1.PodCondition) bool {
+		return c.Type == metadata.ConfigReadyConditionType
+	})
+	if idx >= 0 {
+		existing := pod.Status.Conditions[idx]
+		if existing.Status == status && existing.Reason == reason && existing.Message == message {
+			return nil
+		}
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+	}
+
+	// The kubelet owns the other pod conditions, a strategic merge patch only
+	// touches the condition of this type.
+	orig := pod.DeepCopy()
+	if idx >= 0 {
+		pod.Status.Conditions[idx] = condition
+	} else {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	}
+
+	if err := r.Status().Patch(ctx, pod, client.StrategicMergeFrom(orig)); err != nil {
+		return fmt.Errorf("failed to set condition: %s on pod: %s, error: %w",
+			metadata.ConfigReadyConditionType, pod.GetName(), err)
+	}
+
+	return nil
 }
 
 func (r *PodReconciler) shouldAttemptReconcilation(pod *corev1.Pod) bool {
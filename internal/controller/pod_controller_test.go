@@ -20,17 +20,29 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/version"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 const (
@@ -114,22 +126,1105 @@ var _ = Describe("Pod Controller", func() {
 				})
 			})
 
+			Context("And the telegraf config secret of a previous pod with the same name exists", func() {
+				It("Should replace the stale secret", func() {
+					secret := &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "telegraf-config-stale-secret",
+							Namespace: namespace,
+							Labels:    map[string]string{metadata.SecretManagedByLabelKey: metadata.ControllerName},
+							OwnerReferences: []metav1.OwnerReference{{
+								APIVersion: "v1",
+								Kind:       "Pod",
+								Name:       "stale-secret",
+								UID:        types.UID("0b5e8f2a-3c4d-4e6f-8a9b-1c2d3e4f5a6b"),
+							}},
+						},
+					}
+					Expect(k8sClient.Create(testCtx, secret)).Should(Succeed())
+
+					pod := newTestPod(
+						"stale-secret",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-stale-secret",
+						},
+						map[string]string{},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secretKey := types.NamespacedName{Name: secret.GetName(), Namespace: namespace}
+					Eventually(func() types.UID {
+						s := &corev1.Secret{}
+						if err := k8sClient.Get(testCtx, secretKey, s); err != nil || len(s.GetOwnerReferences()) == 0 {
+							return ""
+						}
+						return s.GetOwnerReferences()[0].UID
+					}, timeout, interval).Should(Equal(pod.GetUID()))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
+			Context("And the telegraf config secret is owned by another pod that still exists", func() {
+				It("Should set the config-ready condition after the retry limit", func() {
+					owner := newTestPod("conflict-owner", map[string]string{}, map[string]string{})
+					Expect(k8sClient.Create(testCtx, owner)).Should(Succeed())
+
+					secret := &corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "telegraf-config-secret-conflict",
+							Namespace: namespace,
+							Labels:    map[string]string{metadata.SecretManagedByLabelKey: metadata.ControllerName},
+							OwnerReferences: []metav1.OwnerReference{{
+								APIVersion: "v1",
+								Kind:       "Pod",
+								Name:       owner.GetName(),
+								UID:        owner.GetUID(),
+							}},
+						},
+					}
+					Expect(k8sClient.Create(testCtx, secret)).Should(Succeed())
+
+					pod := newTestPod(
+						"secret-conflict",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: secret.GetName(),
+						},
+						map[string]string{},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					recorder := record.NewFakeRecorder(1)
+					reconciler := &PodReconciler{
+						Client:           k8sClient,
+						Scheme:           k8sClient.Scheme(),
+						Recorder:         recorder,
+						ClassDataHandler: classDataHandler,
+						DefaultClass:     "testclass",
+					}
+
+					req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+					for range maxSecretConflictRetries - 1 {
+						result, err := reconciler.Reconcile(testCtx, req)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(result.RequeueAfter).Should(Equal(secretConflictRetryInterval))
+					}
+					Expect(recorder.Events).Should(BeEmpty())
+
+					By("Reaching the retry limit")
+					result, err := reconciler.Reconcile(testCtx, req)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.RequeueAfter).Should(Equal(secretConflictBackoff))
+					Expect(recorder.Events).Should(Receive(ContainSubstring("SecretConflict")))
+
+					p := &corev1.Pod{}
+					Expect(k8sClient.Get(testCtx, req.NamespacedName, p)).Should(Succeed())
+					Expect(p.Status.Conditions).Should(ContainElement(And(
+						HaveField("Type", corev1.PodConditionType(metadata.ConfigReadyConditionType)),
+						HaveField("Status", corev1.ConditionFalse),
+						HaveField("Reason", configReadyReasonSecretConflict),
+					)))
+
+					By("Backing off once the retry limit has been reached")
+					result, err = reconciler.Reconcile(testCtx, req)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.RequeueAfter).Should(Equal(secretConflictBackoff))
+					Expect(recorder.Events).Should(BeEmpty())
+
+					cleanUpPod(pod.GetName())
+					cleanUpPod(owner.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
+			Context("And the pod requests the namespaced class of another namespace", func() {
+				It("Should not render the class of the other namespace", func() {
+					pod := newTestPod(
+						"other-namespace-class",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-other-namespace-class",
+						},
+						map[string]string{
+							metadata.TelegrafConfigClassAnnotation: "team-b/payments",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					reconciler := &PodReconciler{
+						Client:   k8sClient,
+						Scheme:   k8sClient.Scheme(),
+						Recorder: record.NewFakeRecorder(10),
+						ClassDataHandler: staticClassDataHandler{
+							"testclass":       []byte("[[outputs.file]]\n  files = [\"stdout\"]\n"),
+							"team-b/payments": []byte("[[outputs.influxdb_v2]]\n  token = \"SECRET-B\"\n"),
+						},
+						DefaultClass: "testclass",
+					}
+
+					req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+					_, err := reconciler.Reconcile(testCtx, req)
+					Expect(err).Should(HaveOccurred())
+					Expect(err.Error()).Should(ContainSubstring("team-b/payments, class names can't contain a slash"))
+
+					secretKey := types.NamespacedName{Name: pod.GetLabels()[metadata.SidecarSecretNameLabel], Namespace: namespace}
+					Consistently(func() bool {
+						return apierrors.IsNotFound(k8sClient.Get(testCtx, secretKey, &corev1.Secret{}))
+					}, time.Second, interval).Should(BeTrue())
+
+					cleanUpPod(pod.GetName())
+				})
+			})
+
+			Context("And the telegraf config secret owned by the pod is out of date", func() {
+				It("Should re-render the secret", func() {
+					pod := newTestPod(
+						"outdated-secret",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-outdated-secret",
+						},
+						map[string]string{},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					secretKey := types.NamespacedName{
+						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+						Namespace: pod.GetNamespace(),
+					}
+					Eventually(func() error {
+						return k8sClient.Get(testCtx, secretKey, secret)
+					}, timeout, interval).Should(Succeed())
+
+					By("Modifying the rendered configuration")
+					secret.Data["telegraf.conf"] = []byte("outdated")
+					Expect(k8sClient.Update(testCtx, secret)).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/minimum-config.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Eventually(func() string {
+						s := &corev1.Secret{}
+						Expect(k8sClient.Get(testCtx, secretKey, s)).Should(Succeed())
+						return string(s.Data["telegraf.conf"])
+					}, timeout, interval).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
+			Context("And the telegraf config of a running pod can't be re-rendered because of an API error", func() {
+				It("Should return the error so that the pod is retried", func() {
+					pod := newTestPod(
+						"rerender-api-error",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-rerender-api-error",
+						},
+						map[string]string{},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secretKey := types.NamespacedName{
+						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+						Namespace: pod.GetNamespace(),
+					}
+					Eventually(func() error {
+						return k8sClient.Get(testCtx, secretKey, &corev1.Secret{})
+					}, timeout, interval).Should(Succeed())
+
+					c, err := client.NewWithWatch(cfg, client.Options{Scheme: k8sClient.Scheme()})
+					Expect(err).ShouldNot(HaveOccurred())
+					reconciler := &PodReconciler{
+						Client: interceptor.NewClient(c, interceptor.Funcs{
+							Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+								opts ...client.GetOption) error {
+								if _, ok := obj.(*corev1.Namespace); ok {
+									return apierrors.NewServiceUnavailable("unavailable")
+								}
+								return c.Get(ctx, key, obj, opts...)
+							},
+						}),
+						Scheme:           k8sClient.Scheme(),
+						Recorder:         record.NewFakeRecorder(10),
+						ClassDataHandler: classDataHandler,
+						DefaultClass:     "testclass",
+						Monitors:         monitorSource,
+					}
+
+					_, err = reconciler.Reconcile(testCtx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+					Expect(apierrors.IsServiceUnavailable(err)).Should(BeTrue())
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secretKey.Name)
+				})
+			})
+
+			Context("And the telegraf annotations of a running pod change", func() {
+				It("Should re-render the secret with the new annotations", func() {
+					pod := newTestPod(
+						"annotation-change",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-annotation-change",
+						},
+						map[string]string{},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					secretKey := types.NamespacedName{
+						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+						Namespace: pod.GetNamespace(),
+					}
+					Eventually(func() error {
+						return k8sClient.Get(testCtx, secretKey, secret)
+					}, timeout, interval).Should(Succeed())
+					Expect(secret.GetAnnotations()).Should(HaveKeyWithValue(metadata.SecretAppliedAnnotationsAnnotation, "{}"))
+
+					By("Annotating the pod with a metrics port")
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						if err := k8sClient.Get(testCtx, key, p); err != nil {
+							return err
+						}
+						p.Annotations = map[string]string{metadata.TelegrafConfigMetricsPortsAnnotation: "8080"}
+						return k8sClient.Update(testCtx, p)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/single-port.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Eventually(func() string {
+						s := &corev1.Secret{}
+						Expect(k8sClient.Get(testCtx, secretKey, s)).Should(Succeed())
+						return string(s.Data["telegraf.conf"])
+					}, timeout, interval).Should(Equal(string(fixture)))
+
+					Expect(k8sClient.Get(testCtx, secretKey, secret)).Should(Succeed())
+					Expect(secret.GetAnnotations()).Should(
+						HaveKeyWithValue(metadata.SecretAppliedAnnotationsAnnotation, `{"ports":"8080"}`))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
 			Context("And the telegraf secret does not already exist", func() {
+				It("Should record the provenance of the configuration", func() {
+					pod := newTestPod(
+						"config-provenance",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-config-provenance",
+						},
+						map[string]string{metadata.TelegrafConfigMetricsPortsAnnotation: "8080"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					class, err := os.ReadFile("../../config/testdata/telegrafClasses/testclass")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassHashLabel, contentHash(class)))
+					Expect(secret.GetAnnotations()).Should(HaveKeyWithValue(metadata.ConfigHashAnnotation,
+						contentHash(secret.Data["telegraf.conf"])))
+					Expect(secret.GetAnnotations()).Should(HaveKeyWithValue(metadata.SecretAnnotationsHashAnnotation,
+						contentHash([]byte(`{"ports":"8080"}`))))
+					Expect(secret.GetAnnotations()).Should(HaveKeyWithValue(metadata.SecretOperatorVersionAnnotation, version.Version))
+
+					By("Mirroring the config hash onto the pod")
+					Eventually(func() map[string]string {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						Expect(k8sClient.Get(testCtx, key, p)).Should(Succeed())
+						return p.GetAnnotations()
+					}, timeout, interval).Should(HaveKeyWithValue(metadata.ConfigHashAnnotation,
+						secret.GetAnnotations()[metadata.ConfigHashAnnotation]))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should reconcile successfully with minimum configuration", func() {
+					pod := newTestPod(
+						"minimum-config",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-minimal-config",
+						},
+						map[string]string{},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					val, ok := secret.GetLabels()[metadata.TelegrafSecretClassNameLabel]
+					Expect(ok).To(BeTrue())
+					Expect(val).To(Equal("testclass"))
+
+					val, ok = secret.GetLabels()[metadata.TelegrafSecretPodLabel]
+					Expect(ok).To(BeTrue())
+					Expect(val).To(Equal(pod.GetName()))
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/minimum-config.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
 				It("Should reconcile successfully with minimum configuration", func() {
 					pod := newTestPod(
-						"minimum-config",
+						"alternate-class",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-alternate-class",
+						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "alternateclass"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					val, ok := secret.GetLabels()[metadata.TelegrafSecretClassNameLabel]
+					Expect(ok).To(BeTrue())
+					Expect(val).To(Equal("alternateclass"))
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/alternate-class.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should fall back to the default class when the requested class doesn't exist", func() {
+					pod := newTestPod(
+						"missing-class",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-missing-class",
+						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "missingclass"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					// The secret keeps the requested class, so that it is re-rendered
+					// once the class is created.
+					val, ok := secret.GetLabels()[metadata.TelegrafSecretClassNameLabel]
+					Expect(ok).To(BeTrue())
+					Expect(val).To(Equal("missingclass"))
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/minimum-config.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					Eventually(func() string {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						if err := k8sClient.Get(testCtx, key, p); err != nil {
+							return ""
+						}
+						return p.GetAnnotations()[metadata.ClassFallbackAnnotation]
+					}, timeout, interval).Should(Equal(string(MissingClassPolicyDefault)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should reconcile successfully with single port annotation", func() {
+					pod := newTestPod(
+						"single-port-annotation",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-single-port-annotation",
+						},
+						map[string]string{metadata.TelegrafConfigMetricsPortsAnnotation: "8080"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/single-port.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should append the port annotation input to the inputs of the class", func() {
+					pod := newTestPod(
+						"appended-plugins",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-appended-plugins",
+						},
+						map[string]string{
+							metadata.TelegrafConfigClassAnnotation:        "prometheusclass",
+							metadata.TelegrafConfigMetricsPortsAnnotation: "8080",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/appended-plugins.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should replace the inputs of the class with the replace-plugins annotation", func() {
+					pod := newTestPod(
+						"replaced-plugins",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-replaced-plugins",
+						},
+						map[string]string{
+							metadata.TelegrafConfigClassAnnotation:          "prometheusclass",
+							metadata.TelegrafConfigMetricsPortsAnnotation:   "8080",
+							metadata.TelegrafConfigReplacePluginsAnnotation: "inputs.prometheus",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/replaced-plugins.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should complete the reconciliation successfully with multiple ports annotation", func() {
+					pod := newTestPod(
+						"multiple-ports-annotation",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-multiple-ports-annotation",
+						},
+						map[string]string{metadata.TelegrafConfigMetricsPortsAnnotation: "8080, 9090, 9091"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/multiple-ports.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should render an input per distinct setting group with the endpoints annotation", func() {
+					pod := newTestPod(
+						"endpoints-annotation",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-endpoints-annotation",
+						},
+						map[string]string{metadata.TelegrafConfigEndpointsAnnotation: `
+- port: 8080
+- port: 9090
+  path: /actuator/prometheus
+  interval: 30s
+  timeout: 5s
+  namedrop: ["jvm_*"]
+`},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/endpoints.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should scrape with the files projected by the scrape auth annotations", func() {
+					pod := newTestPod(
+						"scrape-auth",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-scrape-auth",
+						},
+						map[string]string{
+							metadata.TelegrafConfigMetricsPortsAnnotation:              "8443",
+							metadata.TelegrafConfigMetricsSchemeAnnotation:             "https",
+							metadata.TelegrafConfigTLSCAConfigMapAnnotation:            "kube-root-ca.crt/ca.crt",
+							metadata.TelegrafConfigTLSServerNameAnnotation:             "scrape-auth.default.svc",
+							metadata.TelegrafConfigBearerTokenServiceAccountAnnotation: "true",
+						},
+					)
+					// The scrape auth volume is added by the webhook, which isn't
+					// running in the controller tests.
+					pod.Spec.Volumes = []corev1.Volume{{
+						Name: metadata.ScrapeAuthVolumeName,
+						VolumeSource: corev1.VolumeSource{
+							Projected: &corev1.ProjectedVolumeSource{
+								Sources: []corev1.VolumeProjection{
+									{ConfigMap: &corev1.ConfigMapProjection{
+										LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+										Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: metadata.ScrapeAuthCAFile}},
+									}},
+									{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+										Path: metadata.ScrapeAuthTokenFile,
+									}},
+								},
+							},
+						},
+					}}
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/scrape-auth.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should report a malformed scrape auth reference", func() {
+					pod := newTestPod(
+						"scrape-auth-invalid",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-scrape-auth-invalid",
+						},
+						map[string]string{
+							metadata.TelegrafConfigMetricsPortsAnnotation:      "8443",
+							metadata.TelegrafConfigTLSCASecretAnnotation:       "scrape-ca",
+							metadata.TelegrafConfigBearerTokenSecretAnnotation: "scrape-token/",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					Eventually(func() []string {
+						events := &corev1.EventList{}
+						Expect(k8sClient.List(testCtx, events, client.InNamespace(namespace))).Should(Succeed())
+						var messages []string
+						for _, e := range events.Items {
+							if e.InvolvedObject.Name == pod.GetName() && e.Reason == "InvalidAnnotationFormat" {
+								messages = append(messages, e.Message)
+							}
+						}
+						return messages
+					}, timeout, interval).Should(ContainElement(And(
+						ContainSubstring("invalid value: scrape-ca for "+metadata.TelegrafConfigTLSCASecretAnnotation),
+						ContainSubstring("invalid value: scrape-token/ for "+metadata.TelegrafConfigBearerTokenSecretAnnotation),
+					)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(pod.GetLabels()[metadata.SidecarSecretNameLabel])
+				})
+
+				It("Should apply the metric filter annotations to the prometheus input", func() {
+					pod := newTestPod(
+						"metric-filters",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-metric-filters",
+						},
+						map[string]string{
+							metadata.TelegrafConfigMetricsPortsAnnotation: "8080",
+							metadata.TelegrafConfigMetricsNamedrop:        "go_*, process_*",
+							metadata.TelegrafConfigMetricsFieldpass:       "counter, gauge",
+							metadata.TelegrafConfigMetricsTaginclude:      "['method', 'code']",
+							metadata.TelegrafConfigMetricsTagdrop:         `path: ["/healthz", "/readyz"]`,
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/metric-filters.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should reconcile successfully with prometheus plugin overrides", func() {
+					pod := newTestPod(
+						"prometheus-plugin-overrides",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-prometheus-plugin-overrides",
+						},
+						map[string]string{
+							metadata.TelegrafConfigMetricsPortsAnnotation:  "8080",
+							metadata.TelegrafConfigMetricsSchemeAnnotation: "https",
+							metadata.TelegrafConfigMetricsPathAnnotation:   "/test-path",
+							metadata.TelegrafConfigIntervalAnnotation:      "30s",
+							metadata.TelegrafConfigMetricVersionAnnotation: "2",
+							metadata.TelegrafConfigMetricsNamepass:         "['metric1']",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/prometheus-overrides.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should reconcile successfully with raw input annotation", func() {
+					pod := newTestPod(
+						"raw-input",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-raw-input",
+						},
+						map[string]string{
+							metadata.TelegrafConfigRawInputAnnotation: `
+[[inputs.influxdb_listener]]
+  service_address = ":8186"
+  max_body_size = 0
+  max_line_size = 0
+  read_timeout = "8s"
+  write_timeout = "8s"
+[inputs.influxdb_listener.tags]
+  collectiontype = "application"
+`,
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/raw-input.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should reconcile successfully with internal plugin enabled", func() {
+					pod := newTestPod(
+						"internal-plugin-enabled",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-internal-plugin-enabled",
+						},
+						map[string]string{
+							metadata.TelegrafConfigEnableInternalAnnotation: "yes",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/internal-plugin.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should reconcile successfully with global tags annotation", func() {
+					pod := newTestPod(
+						"global-tags",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-global-tags",
+						},
+						map[string]string{
+							metadata.TelegrafConfigGlobalTagLiteralPrefixAnnotation + "my_tag": "my-value",
+						},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/global-tags.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+
+				It("Should fail reconciliation if raw input is invalid toml", func() {
+					pod := newTestPod(
+						"invalid-raw-input",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-invalid-raw-input",
+						},
+						map[string]string{metadata.TelegrafConfigRawInputAnnotation: "[[inputs.exec]]invalid1"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+						return k8sClient.Get(testCtx, key, p)
+					}, timeout, interval).Should(Succeed())
+
+					secretKey := types.NamespacedName{
+						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+						Namespace: namespace,
+					}
+
+					By("Expecting the secret to not be created with NotFound error")
+					Consistently(func() bool {
+						secret := &corev1.Secret{}
+						err := k8sClient.Get(testCtx, secretKey, secret)
+						if err != nil {
+							return apierrors.IsNotFound(err)
+						}
+						return false
+					}, duration, interval).Should(BeTrue())
+
+					cleanUpPod(pod.GetName())
+				})
+			})
+
+			Context("And the pod has the telegraf config readiness gate", func() {
+				configReadyCondition := func(key types.NamespacedName) *corev1.PodCondition {
+					p := &corev1.Pod{}
+					Expect(k8sClient.Get(testCtx, key, p)).Should(Succeed())
+					for _, c := range p.Status.Conditions {
+						if c.Type == metadata.ConfigReadyConditionType {
+							return &c
+						}
+					}
+					return nil
+				}
+
+				It("Should set the config-ready condition once the secret is created", func() {
+					pod := newTestPod(
+						"readiness-gate",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-readiness-gate",
+						},
+						map[string]string{},
+					)
+					pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: metadata.ConfigReadyConditionType}}
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					podKey := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+					Eventually(func() corev1.ConditionStatus {
+						if c := configReadyCondition(podKey); c != nil {
+							return c.Status
+						}
+						return corev1.ConditionUnknown
+					}, timeout, interval).Should(Equal(corev1.ConditionTrue))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(pod.GetLabels()[metadata.SidecarSecretNameLabel])
+				})
+
+				It("Should set the config-ready condition to false if rendering fails", func() {
+					pod := newTestPod(
+						"readiness-gate-invalid",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-readiness-gate-invalid",
+						},
+						map[string]string{metadata.TelegrafConfigRawInputAnnotation: "[[inputs.exec]]invalid1"},
+					)
+					pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: metadata.ConfigReadyConditionType}}
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					podKey := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+					Eventually(func() *corev1.PodCondition {
+						return configReadyCondition(podKey)
+					}, timeout, interval).ShouldNot(BeNil())
+
+					condition := configReadyCondition(podKey)
+					Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+					Expect(condition.Reason).To(Equal("ConfigRenderFailed"))
+					Expect(condition.Message).To(ContainSubstring("error building telegraf configuration"))
+
+					cleanUpPod(pod.GetName())
+				})
+
+				It("Should set the config-ready condition to false if re-rendering a running pod fails", func() {
+					pod := newTestPod(
+						"readiness-gate-rerender",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-readiness-gate-rerender",
+						},
+						map[string]string{},
+					)
+					pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: metadata.ConfigReadyConditionType}}
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					podKey := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
+					Eventually(func() corev1.ConditionStatus {
+						if c := configReadyCondition(podKey); c != nil {
+							return c.Status
+						}
+						return corev1.ConditionUnknown
+					}, timeout, interval).Should(Equal(corev1.ConditionTrue))
+
+					secretKey := types.NamespacedName{
+						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+						Namespace: pod.GetNamespace(),
+					}
+					secret := &corev1.Secret{}
+					Expect(k8sClient.Get(testCtx, secretKey, secret)).Should(Succeed())
+
+					By("Requesting a class that can't be rendered")
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						Expect(k8sClient.Get(testCtx, podKey, p)).Should(Succeed())
+						metav1.SetMetaDataAnnotation(&p.ObjectMeta, metadata.TelegrafConfigClassAnnotation, "team-b/payments")
+						return k8sClient.Update(testCtx, p)
+					}, timeout, interval).Should(Succeed())
+
+					Eventually(func() *corev1.PodCondition {
+						return configReadyCondition(podKey)
+					}, timeout, interval).Should(And(
+						HaveField("Status", corev1.ConditionFalse),
+						HaveField("Reason", "ConfigRenderFailed"),
+						HaveField("Message", ContainSubstring("class names can't contain a slash")),
+					))
+
+					// The pod keeps running with its existing configuration, which
+					// is labelled as outdated.
+					s := &corev1.Secret{}
+					Eventually(func() map[string]string {
+						Expect(k8sClient.Get(testCtx, secretKey, s)).Should(Succeed())
+						return s.GetLabels()
+					}, timeout, interval).Should(HaveKeyWithValue(metadata.TelegrafSecretOutdatedLabel, "true"))
+					Expect(s.Data["telegraf.conf"]).Should(Equal(secret.Data["telegraf.conf"]))
+
+					By("Requesting a class that can be rendered again")
+					Eventually(func() error {
+						p := &corev1.Pod{}
+						Expect(k8sClient.Get(testCtx, podKey, p)).Should(Succeed())
+						metav1.SetMetaDataAnnotation(&p.ObjectMeta, metadata.TelegrafConfigClassAnnotation, "testclass")
+						return k8sClient.Update(testCtx, p)
+					}, timeout, interval).Should(Succeed())
+
+					Eventually(func() map[string]string {
+						Expect(k8sClient.Get(testCtx, secretKey, s)).Should(Succeed())
+						return s.GetLabels()
+					}, timeout, interval).ShouldNot(HaveKey(metadata.TelegrafSecretOutdatedLabel))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secretKey.Name)
+				})
+			})
+
+			Context("And the requested class is not allowed in the namespace of the pod", func() {
+				It("Should fall back to the default class", func() {
+					pod := newTestPod(
+						"restricted-class",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-restricted-class",
+						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "restrictedclass"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					Eventually(func() error {
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
+					}, timeout, interval).Should(Succeed())
+
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassNameLabel, "testclass"))
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/minimum-config.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
+			Context("And the requested class inherits from a class not allowed in the namespace of the pod", func() {
+				It("Should fall back to the default class", func() {
+					pod := newTestPod(
+						"inheriting-restricted-class",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-minimal-config",
+							metadata.SidecarSecretNameLabel: "telegraf-config-inheriting-restricted-class",
 						},
-						map[string]string{},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "inheritingclass"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
-					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
-					}, timeout, interval).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -140,37 +1235,25 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					val, ok := secret.GetLabels()[metadata.TelegrafSecretClassNameLabel]
-					Expect(ok).To(BeTrue())
-					Expect(val).To(Equal("testclass"))
-
-					val, ok = secret.GetLabels()[metadata.TelegrafSecretPodLabel]
-					Expect(ok).To(BeTrue())
-					Expect(val).To(Equal(pod.GetName()))
-
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/minimum-config.toml")
-					Expect(err).ShouldNot(HaveOccurred())
-					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassNameLabel, "testclass"))
+					Expect(string(secret.Data["telegraf.conf"])).ShouldNot(ContainSubstring("restricted"))
 
 					cleanUpPod(pod.GetName())
 					cleanUpSecret(secret.GetName())
 				})
+			})
 
-				It("Should reconcile successfully with minimum configuration", func() {
+			Context("And the pod requests the canary revision of a class not allowed in its namespace", func() {
+				It("Should fall back to the default class", func() {
 					pod := newTestPod(
-						"alternate-class",
+						"restricted-canary-class",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-alternate-class",
+							metadata.SidecarSecretNameLabel: "telegraf-config-restricted-canary-class",
 						},
-						map[string]string{metadata.TelegrafConfigClassAnnotation: "alternateclass"},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "restrictedclass.canary"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
-					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
-					}, timeout, interval).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -181,33 +1264,26 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					val, ok := secret.GetLabels()[metadata.TelegrafSecretClassNameLabel]
-					Expect(ok).To(BeTrue())
-					Expect(val).To(Equal("alternateclass"))
-
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/alternate-class.toml")
-					Expect(err).ShouldNot(HaveOccurred())
-					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassNameLabel, "testclass"))
+					Expect(string(secret.Data["telegraf.conf"])).ShouldNot(ContainSubstring("restricted"))
 
 					cleanUpPod(pod.GetName())
 					cleanUpSecret(secret.GetName())
 				})
+			})
 
-				It("Should reconcile successfully with single port annotation", func() {
+			Context("And the pod matches a class policy selection rule", func() {
+				It("Should use the class selected by the rule", func() {
 					pod := newTestPod(
-						"single-port-annotation",
+						"rule-selected-class",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-single-port-annotation",
+							metadata.SidecarSecretNameLabel: "telegraf-config-rule-selected-class",
+							"telegraf-class":                "alternate",
 						},
-						map[string]string{metadata.TelegrafConfigMetricsPortsAnnotation: "8080"},
+						map[string]string{},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
-					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
-					}, timeout, interval).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -218,29 +1294,28 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/single-port.toml")
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassNameLabel, "alternateclass"))
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/alternate-class.toml")
 					Expect(err).ShouldNot(HaveOccurred())
 					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
 
 					cleanUpPod(pod.GetName())
 					cleanUpSecret(secret.GetName())
 				})
+			})
 
-				It("Should complete the reconciliation successfully with multiple ports annotation", func() {
+			Context("And the pod requests more than one class", func() {
+				It("Should merge the classes in order and append their outputs", func() {
 					pod := newTestPod(
-						"multiple-ports-annotation",
+						"composed-classes",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-multiple-ports-annotation",
+							metadata.SidecarSecretNameLabel: "telegraf-config-composed-classes",
 						},
-						map[string]string{metadata.TelegrafConfigMetricsPortsAnnotation: "8080, 9090, 9091"},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "testclass, debugclass"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
-					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
-					}, timeout, interval).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -251,7 +1326,11 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/multiple-ports.toml")
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassNameLabel, "testclass"))
+					Expect(secret.GetAnnotations()).Should(HaveKeyWithValue(metadata.SecretComposedClassesAnnotation,
+						"testclass,debugclass"))
+
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/composed-classes.toml")
 					Expect(err).ShouldNot(HaveOccurred())
 					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
 
@@ -259,28 +1338,60 @@ var _ = Describe("Pod Controller", func() {
 					cleanUpSecret(secret.GetName())
 				})
 
-				It("Should reconcile successfully with prometheus plugin overrides", func() {
+				It("Should re-render the secret when a class other than the first one changes", func() {
 					pod := newTestPod(
-						"prometheus-plugin-overrides",
+						"composed-classes-changed",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-prometheus-plugin-overrides",
-						},
-						map[string]string{
-							metadata.TelegrafConfigMetricsPortsAnnotation:  "8080",
-							metadata.TelegrafConfigMetricsSchemeAnnotation: "https",
-							metadata.TelegrafConfigMetricsPathAnnotation:   "/test-path",
-							metadata.TelegrafConfigIntervalAnnotation:      "30s",
-							metadata.TelegrafConfigMetricVersionAnnotation: "2",
-							metadata.TelegrafConfigMetricsNamepass:         "['metric1']",
+							metadata.SidecarSecretNameLabel: "telegraf-config-composed-classes-changed",
 						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "testclass,debugclass"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
+					secretKey := types.NamespacedName{
+						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+						Namespace: pod.GetNamespace(),
+					}
 					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
+						return k8sClient.Get(testCtx, secretKey, secret)
 					}, timeout, interval).Should(Succeed())
+					classHash := secret.GetLabels()[metadata.TelegrafSecretClassHashLabel]
+
+					By("Changing the second class")
+					classFile := filepath.Join(classesDir, "debugclass")
+					original, err := os.ReadFile(classFile)
+					Expect(err).ShouldNot(HaveOccurred())
+					changed := strings.Replace(string(original), `files = ["stderr"]`, `files = ["stderr", "/tmp/debug"]`, 1)
+					Expect(os.WriteFile(classFile, []byte(changed), 0o644)).Should(Succeed())
+					Expect(classDataHandler.Update()).Should(Succeed())
+
+					Eventually(func() string {
+						Expect(k8sClient.Get(testCtx, secretKey, secret)).Should(Succeed())
+						return string(secret.Data["telegraf.conf"])
+					}, timeout, interval).Should(ContainSubstring("/tmp/debug"))
+					Expect(secret.GetLabels()[metadata.TelegrafSecretClassHashLabel]).ShouldNot(Equal(classHash))
+
+					Expect(os.WriteFile(classFile, original, 0o644)).Should(Succeed())
+					Expect(classDataHandler.Update()).Should(Succeed())
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
+			Context("And the requested class has a canary revision", func() {
+				It("Should render the canary revision and record it on the secret", func() {
+					pod := newTestPod(
+						"canary-class",
+						map[string]string{
+							metadata.SidecarInjectedLabel:   "true",
+							metadata.SidecarSecretNameLabel: "telegraf-config-canary-class",
+						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "canaryclass"},
+					)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -291,40 +1402,39 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/prometheus-overrides.toml")
-					Expect(err).ShouldNot(HaveOccurred())
-					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassNameLabel, "canaryclass"))
+					Expect(secret.GetLabels()).Should(HaveKeyWithValue(metadata.TelegrafSecretClassRevisionLabel,
+						metadata.ClassRevisionCanary))
+					Expect(string(secret.Data["telegraf.conf"])).Should(ContainSubstring("stderr"))
+					Expect(string(secret.Data["telegraf.conf"])).ShouldNot(ContainSubstring("operator"))
 
 					cleanUpPod(pod.GetName())
 					cleanUpSecret(secret.GetName())
 				})
+			})
 
-				It("Should reconcile successfully with raw input annotation", func() {
+			Context("With class templates feature gate", func() {
+				BeforeEach(func() {
+					err := featuregate.Set("telegraf.classtemplates", true)
+					Expect(err).ShouldNot(HaveOccurred())
+				})
+
+				AfterEach(func() {
+					err := featuregate.Set("telegraf.classtemplates", false)
+					Expect(err).ShouldNot(HaveOccurred())
+				})
+
+				It("Should render the class template with the pod metadata", func() {
 					pod := newTestPod(
-						"raw-input",
+						"templated-class",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-raw-input",
-						},
-						map[string]string{
-							metadata.TelegrafConfigRawInputAnnotation: `
-[[inputs.influxdb_listener]]
-  service_address = ":8186"
-  max_body_size = 0
-  max_line_size = 0
-  read_timeout = "8s"
-  write_timeout = "8s"
-[inputs.influxdb_listener.tags]
-  collectiontype = "application"
-`,
+							metadata.SidecarSecretNameLabel: "telegraf-config-templated-class",
+							"app":                           "payments",
 						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "templatedclass"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
-					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
-					}, timeout, interval).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -335,7 +1445,7 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/raw-input.toml")
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/templated-class.toml")
 					Expect(err).ShouldNot(HaveOccurred())
 					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
 
@@ -343,23 +1453,20 @@ var _ = Describe("Pod Controller", func() {
 					cleanUpSecret(secret.GetName())
 				})
 
-				It("Should reconcile successfully with internal plugin enabled", func() {
+				It("Should escape pod metadata rendered into the class template", func() {
+					team := "payments\"\n[[outputs.http]]\n  url = \"http://example.com\"\n#"
 					pod := newTestPod(
-						"internal-plugin-enabled",
+						"annotation-templated-class",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-internal-plugin-enabled",
+							metadata.SidecarSecretNameLabel: "telegraf-config-annotation-templated-class",
 						},
 						map[string]string{
-							metadata.TelegrafConfigEnableInternalAnnotation: "yes",
+							metadata.TelegrafConfigClassAnnotation: "annotationtemplatedclass",
+							"example.com/team":                     team,
 						},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
-					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
-					}, timeout, interval).Should(Succeed())
 
 					secret := &corev1.Secret{}
 					Eventually(func() error {
@@ -370,32 +1477,49 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/internal-plugin.toml")
+					var config struct {
+						Outputs    map[string]any    `toml:"outputs"`
+						GlobalTags map[string]string `toml:"global_tags"`
+					}
+					_, err := toml.Decode(string(secret.Data["telegraf.conf"]), &config)
 					Expect(err).ShouldNot(HaveOccurred())
-					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+					Expect(config.Outputs).Should(HaveLen(1))
+					Expect(config.Outputs).Should(HaveKey("file"))
+					Expect(config.GlobalTags).Should(HaveKeyWithValue("team", team))
 
 					cleanUpPod(pod.GetName())
 					cleanUpSecret(secret.GetName())
 				})
 
-				It("Should reconcile successfully with global tags annotation", func() {
+				It("Should use classes that don't opt in to templates as is", func() {
 					pod := newTestPod(
-						"global-tags",
+						"untemplated-class",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-global-tags",
-						},
-						map[string]string{
-							metadata.TelegrafConfigGlobalTagLiteralPrefixAnnotation + "my_tag": "my-value",
+							metadata.SidecarSecretNameLabel: "telegraf-config-untemplated-class",
 						},
+						map[string]string{metadata.TelegrafConfigClassAnnotation: "untemplatedclass"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
 					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
+					Expect(string(secret.Data["telegraf.conf"])).Should(ContainSubstring(`namespace = "{{ .Pod.Namespace }}"`))
+
+					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
+				})
+			})
+
+			Context("And the pod is selected by a monitor", func() {
+				renderedSecret := func(pod *corev1.Pod) *corev1.Secret {
 					secret := &corev1.Secret{}
 					Eventually(func() error {
 						key := types.NamespacedName{
@@ -405,46 +1529,118 @@ var _ = Describe("Pod Controller", func() {
 						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					fixture, err := os.ReadFile("../../config/testdata/fixtures/global-tags.toml")
+					return secret
+				}
+
+				It("Should add a prometheus input for a PodMonitor in a namespace that opted in to monitors", func() {
+					ns := createTestNamespace("controller-podmonitor", true)
+					monitor := newTestMonitor(monitors.PodMonitorGVK, ns, map[string]any{"app": "web"})
+					Expect(k8sClient.Create(testCtx, monitor)).Should(Succeed())
+
+					pod := newMonitoredTestPod(ns, "podmonitor", "web")
+					waitForMonitorSelection(pod)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := renderedSecret(pod)
+					Expect(string(secret.Data["telegraf.conf"])).Should(ContainSubstring(`urls = ["http://localhost:9090/metrics"]`))
+
+					cleanUpObject(pod)
+					cleanUpObject(secret)
+					cleanUpObject(monitor)
+				})
+
+				It("Should add a prometheus input for a ServiceMonitor in a namespace that opted in to monitors", func() {
+					ns := createTestNamespace("controller-servicemonitor", true)
+					monitor := newTestMonitor(monitors.ServiceMonitorGVK, ns, map[string]any{"monitored": "true"})
+					Expect(k8sClient.Create(testCtx, monitor)).Should(Succeed())
+					service := &corev1.Service{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "api",
+							Namespace: ns,
+							Labels:    map[string]string{"monitored": "true"},
+						},
+						Spec: corev1.ServiceSpec{
+							Selector: map[string]string{"app": "api"},
+							Ports: []corev1.ServicePort{{
+								Name:       "metrics",
+								Port:       80,
+								TargetPort: intstr.FromString("metrics"),
+							}},
+						},
+					}
+					Expect(k8sClient.Create(testCtx, service)).Should(Succeed())
+
+					pod := newMonitoredTestPod(ns, "servicemonitor", "api")
+					waitForMonitorSelection(pod)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := renderedSecret(pod)
+					Expect(string(secret.Data["telegraf.conf"])).Should(ContainSubstring(`urls = ["http://localhost:9090/metrics"]`))
+
+					cleanUpObject(pod)
+					cleanUpObject(secret)
+					cleanUpObject(service)
+					cleanUpObject(monitor)
+				})
+
+				It("Should not add a prometheus input in a namespace that didn't opt in to monitors", func() {
+					ns := createTestNamespace("controller-unmonitored", false)
+					monitor := newTestMonitor(monitors.PodMonitorGVK, ns, map[string]any{"app": "web"})
+					Expect(k8sClient.Create(testCtx, monitor)).Should(Succeed())
+
+					pod := newMonitoredTestPod(ns, "unmonitored", "web")
+					waitForMonitorSelection(pod)
+					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := renderedSecret(pod)
+					Expect(string(secret.Data["telegraf.conf"])).ShouldNot(ContainSubstring("inputs.prometheus"))
+
+					cleanUpObject(pod)
+					cleanUpObject(secret)
+					cleanUpObject(monitor)
+				})
+			})
+
+			Context("With prometheus annotations feature gate", func() {
+				BeforeEach(func() {
+					err := featuregate.Set("telegraf.prometheusannotations", true)
 					Expect(err).ShouldNot(HaveOccurred())
-					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
+				})
 
-					cleanUpPod(pod.GetName())
-					cleanUpSecret(secret.GetName())
+				AfterEach(func() {
+					err := featuregate.Set("telegraf.prometheusannotations", false)
+					Expect(err).ShouldNot(HaveOccurred())
 				})
 
-				It("Should fail reconciliation if raw input is invalid toml", func() {
+				It("Should scrape the port of the prometheus.io annotations", func() {
 					pod := newTestPod(
-						"invalid-raw-input",
+						"prometheus-annotations",
 						map[string]string{
 							metadata.SidecarInjectedLabel:   "true",
-							metadata.SidecarSecretNameLabel: "telegraf-config-invalid-raw-input",
+							metadata.SidecarSecretNameLabel: "telegraf-config-prometheus-annotations",
+						},
+						map[string]string{
+							metadata.PrometheusScrapeAnnotation: "true",
+							metadata.PrometheusPortAnnotation:   "8080",
 						},
-						map[string]string{metadata.TelegrafConfigRawInputAnnotation: "[[inputs.exec]]invalid1"},
 					)
 					Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+					secret := &corev1.Secret{}
 					Eventually(func() error {
-						p := &corev1.Pod{}
-						key := types.NamespacedName{Name: pod.GetName(), Namespace: pod.GetNamespace()}
-						return k8sClient.Get(testCtx, key, p)
+						key := types.NamespacedName{
+							Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
+							Namespace: pod.GetNamespace(),
+						}
+						return k8sClient.Get(testCtx, key, secret)
 					}, timeout, interval).Should(Succeed())
 
-					secretKey := types.NamespacedName{
-						Name:      pod.GetLabels()[metadata.SidecarSecretNameLabel],
-						Namespace: namespace,
-					}
-
-					By("Expecting the secret to not be created with NotFound error")
-					Consistently(func() bool {
-						secret := &corev1.Secret{}
-						err := k8sClient.Get(testCtx, secretKey, secret)
-						if err != nil {
-							return apierrors.IsNotFound(err)
-						}
-						return false
-					}, duration, interval).Should(BeTrue())
+					fixture, err := os.ReadFile("../../config/testdata/fixtures/single-port.toml")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(string(secret.Data["telegraf.conf"])).Should(Equal(string(fixture)))
 
 					cleanUpPod(pod.GetName())
+					cleanUpSecret(secret.GetName())
 				})
 			})
 
@@ -723,6 +1919,72 @@ func newTestPod(name string, labels map[string]string, annotations map[string]st
 	}
 }
 
+// newMonitoredTestPod returns an injected pod with the app label and a metrics
+// port, for monitors to select.
+func newMonitoredTestPod(namespace, name, app string) *corev1.Pod {
+	pod := newTestPod(
+		name,
+		map[string]string{
+			metadata.SidecarInjectedLabel:   "true",
+			metadata.SidecarSecretNameLabel: "telegraf-config-" + name,
+			"app":                           app,
+		},
+		map[string]string{},
+	)
+	pod.SetNamespace(namespace)
+	pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9090}}
+
+	return pod
+}
+
+// createTestNamespace creates a namespace, which optionally opted in to
+// monitors. Namespaces aren't removed by envtest, so they aren't cleaned up.
+func createTestNamespace(name string, monitored bool) string {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if monitored {
+		ns.SetLabels(map[string]string{metadata.TelegrafMonitorsNamespaceLabel: "true"})
+	}
+	Expect(k8sClient.Create(testCtx, ns)).Should(Succeed())
+
+	return name
+}
+
+// newTestMonitor returns a PodMonitor or ServiceMonitor named metrics whose
+// selector matches labels.
+func newTestMonitor(gvk schema.GroupVersionKind, namespace string, labels map[string]any) *unstructured.Unstructured {
+	endpoints := "podMetricsEndpoints"
+	if gvk == monitors.ServiceMonitorGVK {
+		endpoints = "endpoints"
+	}
+
+	monitor := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"selector": map[string]any{"matchLabels": labels},
+			endpoints:  []any{map[string]any{"port": "metrics"}},
+		},
+	}}
+	monitor.SetGroupVersionKind(gvk)
+	monitor.SetNamespace(namespace)
+	monitor.SetName("metrics")
+
+	return monitor
+}
+
+// waitForMonitorSelection waits for the monitors selecting pod to be in the
+// cache of the controller, so that it sees them when the pod is reconciled.
+func waitForMonitorSelection(pod *corev1.Pod) {
+	Eventually(func() (bool, error) {
+		return monitorSource.Selects(testCtx, pod)
+	}, timeout, interval).Should(BeTrue())
+}
+
+func cleanUpObject(obj client.Object) {
+	Expect(client.IgnoreNotFound(k8sClient.Delete(testCtx, obj))).Should(Succeed())
+	Eventually(func() bool {
+		return apierrors.IsNotFound(k8sClient.Get(testCtx, client.ObjectKeyFromObject(obj), obj))
+	}, timeout, interval).Should(BeTrue())
+}
+
 func cleanUpPod(name string) {
 	podKey := types.NamespacedName{Name: name, Namespace: namespace}
 	Eventually(func() error {
@@ -755,3 +2017,18 @@ func cleanUpSecret(name string) {
 		return k8sClient.Get(testCtx, secretKey, s)
 	}, timeout, interval).ShouldNot(Succeed())
 }
+
+// staticClassDataHandler serves a fixed set of classes, such as the namespaced
+// classes that can't be defined in the classes directory.
+type staticClassDataHandler map[string][]byte
+
+func (h staticClassDataHandler) GetDataForClass(name string) ([]byte, bool) {
+	data, ok := h[name]
+	return data, ok
+}
+
+func (h staticClassDataHandler) Update() error { return nil }
+
+func (h staticClassDataHandler) Subscribe(func(classes []string)) {}
+
+func (h staticClassDataHandler) HasSynced() bool { return true }
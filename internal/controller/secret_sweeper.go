@@ -0,0 +1,189 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+)
+
+var orphanedSecretsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "orphaned_secrets_deleted_total",
+	Help:      "Number of telegraf config secrets deleted because no live pod owns them.",
+}, []string{"namespace"})
+
+var orphanedSecretsPending = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "orphaned_secrets",
+	Help:      "Number of telegraf config secrets without a live owning pod, waiting for the grace period to pass.",
+})
+
+// SecretSweeper periodically deletes the telegraf config secrets that no live
+// pod owns. Secrets are normally garbage collected through their owner
+// reference, which doesn't cover secrets whose owner reference was never set,
+// or whose pod was replaced by a pod of the same name.
+type SecretSweeper struct {
+	client.Client
+	// Interval between sweeps.
+	Interval time.Duration
+	// GracePeriod is how long a secret has to be orphaned for before it is
+	// deleted, so that a secret isn't deleted while its pod is still being
+	// created or garbage collected.
+	GracePeriod time.Duration
+
+	// orphanedSince records when each orphaned secret was first found.
+	orphanedSince map[types.UID]time.Time
+}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=list;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get
+
+// SetupWithManager adds the sweeper to the Manager.
+func (s *SecretSweeper) SetupWithManager(mgr ctrl.Manager) error {
+	if err := metrics.Registry.Register(orphanedSecretsDeleted); err != nil {
+		return fmt.Errorf("failed to register orphaned secrets metric: %w", err)
+	}
+	if err := metrics.Registry.Register(orphanedSecretsPending); err != nil {
+		return fmt.Errorf("failed to register orphaned secrets metric: %w", err)
+	}
+
+	if err := mgr.Add(s); err != nil {
+		return fmt.Errorf("failed to add secret sweeper: %w", err)
+	}
+
+	return nil
+}
+
+// Start sweeps the orphaned secrets every interval until ctx is cancelled. It
+// implements manager.Runnable, and only runs on the leader.
+func (s *SecretSweeper) Start(ctx context.Context) error {
+	log := logf.FromContext(ctx).WithName("secret-sweeper")
+
+	s.orphanedSince = make(map[types.UID]time.Time)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := s.sweep(ctx); err != nil {
+			log.Error(err, "failed to sweep orphaned telegraf config secrets")
+		}
+	}
+}
+
+// sweep deletes the secrets that have been orphaned for longer than the grace
+// period, and records when the other orphaned secrets were first found.
+func (s *SecretSweeper) sweep(ctx context.Context) error {
+	log := logf.FromContext(ctx).WithName("secret-sweeper")
+
+	secrets := &corev1.SecretList{}
+	if err := s.List(ctx, secrets,
+		client.MatchingLabels{metadata.SecretManagedByLabelKey: metadata.ControllerName},
+	); err != nil {
+		return fmt.Errorf("failed to list telegraf config secrets: %w", err)
+	}
+
+	now := time.Now()
+	orphaned := make(map[types.UID]time.Time)
+	for _, secret := range secrets.Items {
+		if !secret.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		owned, err := hasLiveOwner(ctx, s.Client, &secret)
+		if err != nil {
+			log.Error(err, "failed to look up owner of secret", "secret", client.ObjectKeyFromObject(&secret))
+			continue
+		}
+		if owned {
+			continue
+		}
+
+		since, ok := s.orphanedSince[secret.GetUID()]
+		if !ok {
+			since = now
+		}
+		if now.Sub(since) < s.GracePeriod {
+			orphaned[secret.GetUID()] = since
+			continue
+		}
+
+		// The preconditions ensure that a secret that has been adopted or
+		// replaced since it was listed isn't deleted.
+		uid, resourceVersion := secret.GetUID(), secret.GetResourceVersion()
+		err = s.Delete(ctx, &secret, client.Preconditions{UID: &uid, ResourceVersion: &resourceVersion})
+		switch {
+		case err == nil:
+			orphanedSecretsDeleted.WithLabelValues(secret.GetNamespace()).Inc()
+			log.Info("deleted orphaned telegraf config secret", "secret", client.ObjectKeyFromObject(&secret),
+				"orphanedFor", now.Sub(since).Round(time.Second))
+		case apierrors.IsNotFound(err), apierrors.IsConflict(err):
+		default:
+			orphaned[secret.GetUID()] = since
+			log.Error(err, "failed to delete orphaned telegraf config secret", "secret", client.ObjectKeyFromObject(&secret))
+		}
+	}
+
+	s.orphanedSince = orphaned
+	orphanedSecretsPending.Set(float64(len(orphaned)))
+
+	return nil
+}
+
+// hasLiveOwner returns whether the pod owning secret exists. Only a pod with
+// the UID of the owner reference counts, a pod that was re-created with the
+// same name doesn't own the secret of the previous pod.
+func hasLiveOwner(ctx context.Context, reader client.Reader, secret *corev1.Secret) (bool, error) {
+	for _, owner := range secret.GetOwnerReferences() {
+		if owner.Kind != "Pod" {
+			continue
+		}
+
+		pod := &corev1.Pod{}
+		err := reader.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: secret.GetNamespace()}, pod)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get pod: %s, error: %w", owner.Name, err)
+		}
+		if pod.GetUID() == owner.UID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
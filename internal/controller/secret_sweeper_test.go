@@ -0,0 +1,78 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Secret Sweeper", func() {
+	Context("When a telegraf config secret isn't owned by a live pod", func() {
+		It("Should delete the secret after the grace period", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "telegraf-config-orphaned",
+					Namespace: namespace,
+					Labels:    map[string]string{metadata.SecretManagedByLabelKey: metadata.ControllerName},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "v1",
+						Kind:       "Pod",
+						Name:       "orphaned",
+						UID:        types.UID("6d7a3c1e-0f6b-4e55-9a57-5f3b2a1c9d42"),
+					}},
+				},
+			}
+			Expect(k8sClient.Create(testCtx, secret)).Should(Succeed())
+
+			key := types.NamespacedName{Name: secret.GetName(), Namespace: namespace}
+			Eventually(func() error {
+				return k8sClient.Get(testCtx, key, &corev1.Secret{})
+			}, timeout, interval).ShouldNot(Succeed())
+		})
+	})
+
+	Context("When a telegraf config secret is owned by a live pod", func() {
+		It("Should keep the secret", func() {
+			pod := newTestPod(
+				"sweeper-live-owner",
+				map[string]string{
+					metadata.SidecarInjectedLabel:   "true",
+					metadata.SidecarSecretNameLabel: "telegraf-config-sweeper-live-owner",
+				},
+				map[string]string{},
+			)
+			Expect(k8sClient.Create(testCtx, pod)).Should(Succeed())
+
+			key := types.NamespacedName{Name: pod.GetLabels()[metadata.SidecarSecretNameLabel], Namespace: namespace}
+			Eventually(func() error {
+				return k8sClient.Get(testCtx, key, &corev1.Secret{})
+			}, timeout, interval).Should(Succeed())
+			Consistently(func() error {
+				return k8sClient.Get(testCtx, key, &corev1.Secret{})
+			}, duration, interval).Should(Succeed())
+
+			cleanUpPod(pod.GetName())
+			cleanUpSecret(key.Name)
+		})
+	})
+})
@@ -19,11 +19,16 @@ package controller
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/jmickey/telegraf-sidecar-operator/api/v1alpha1"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classpolicy"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
@@ -40,11 +45,30 @@ import (
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
+// testClassPolicy makes restrictedclass unavailable to all namespaces, and
+// selects alternateclass for pods labelled with telegraf-class=alternate.
+const testClassPolicy = `
+action: fallback
+classes:
+  - name: restrictedclass
+rules:
+  - class: alternateclass
+    podSelector:
+      matchLabels:
+        telegraf-class: alternate
+`
+
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
 var ctx context.Context
 var cancel context.CancelFunc
+var monitorSource *monitors.Source
+
+// The classes are loaded from a copy of the test classes, so that the tests
+// can change them.
+var classesDir string
+var classDataHandler *classdata.DirectoryHandler
 
 func TestControllers(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -59,7 +83,10 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "config", "crd", "bases"),
+			filepath.Join("..", "..", "config", "testdata", "crds"),
+		},
 		ErrorIfCRDPathMissing: false,
 
 		// The BinaryAssetsDirectory is only required if you want to run the tests directly
@@ -77,6 +104,9 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
+	err = v1alpha1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	//+kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
@@ -88,7 +118,17 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	classDataHandler, err := classdata.NewDirectoryHandler("../../config/testdata/telegrafClasses")
+	classesDir = GinkgoT().TempDir()
+	err = os.CopyFS(classesDir, os.DirFS(filepath.Join("..", "..", "config", "testdata", "telegrafClasses")))
+	Expect(err).NotTo(HaveOccurred())
+
+	classDataHandler, err = classdata.NewDirectoryHandler(classesDir)
+	Expect(err).NotTo(HaveOccurred())
+
+	monitorSource, err = monitors.NewSource(ctx, mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	classPolicy, err := classpolicy.Parse([]byte(testClassPolicy))
 	Expect(err).NotTo(HaveOccurred())
 
 	err = (&PodReconciler{
@@ -98,6 +138,22 @@ var _ = BeforeSuite(func() {
 		ClassDataHandler:     classDataHandler,
 		DefaultClass:         "testclass",
 		EnableInternalPlugin: false,
+		ClassPolicy:          classPolicy,
+		MissingClassPolicy:   MissingClassPolicyDefault,
+		Monitors:             monitorSource,
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&SecretSweeper{
+		Client:      mgr.GetClient(),
+		Interval:    500 * time.Millisecond,
+		GracePeriod: time.Second,
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&TelegrafClassReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
@@ -17,43 +17,167 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
 	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
 )
 
 const (
 	defaultInterval = 10 * time.Second
+
+	// contentHashLength keeps hashes short enough to be used as label values.
+	contentHashLength = 16
 )
 
 type annotationValues struct {
 	classDataHandler classdata.Handler
 	globalTags       map[string]string
+	pod              *corev1.Pod
 	class            string
+	classHash        string
 	metricsPath      string
 	scheme           string
-	namepass         string
+	filters          metricFilters
 	rawInput         string
 	rawAggregators   string
 	rawProcessors    string
 	ports            []uint16
+	endpoints        []endpoint
 	interval         time.Duration
 	metricVersion    uint8
 	enableInternal   bool
+	canary           bool
+	// namespacedClasses are the classes that were found in the namespace of
+	// the pod, see lookupClass.
+	namespacedClasses []string
+	replacePlugins    []string
+	// scrapeAuth are the TLS and authentication settings of the inputs
+	// generated for the ports and endpoints annotations.
+	scrapeAuth scrapeAuth
+	// monitorTargets are the endpoints of the PodMonitors and ServiceMonitors
+	// selecting the pod.
+	monitorTargets []monitors.Target
+	// missingClassPolicy decides how a class that doesn't exist is rendered,
+	// with fallbackClass used by MissingClassPolicyDefault.
+	missingClassPolicy MissingClassPolicy
+	fallbackClass      string
+	// missingClasses are the requested classes that don't exist.
+	missingClasses []string
+	// agentConflicts describes the agent settings of a class that were
+	// overridden by a later class.
+	agentConflicts []string
+}
+
+// MissingClassPolicy is how the configuration of a pod requesting a class that
+// doesn't exist is rendered.
+type MissingClassPolicy string
+
+const (
+	// MissingClassPolicyFail fails rendering the configuration of the pod.
+	MissingClassPolicyFail MissingClassPolicy = "fail"
+	// MissingClassPolicyDefault renders the missing class with the default
+	// class of the pod.
+	MissingClassPolicyDefault MissingClassPolicy = "default"
+	// MissingClassPolicyMinimal renders the missing class with minimalClassData.
+	MissingClassPolicyMinimal MissingClassPolicy = "minimal"
+)
+
+// MissingClassPolicies are the valid values of MissingClassPolicy.
+var MissingClassPolicies = []MissingClassPolicy{
+	MissingClassPolicyFail, MissingClassPolicyDefault, MissingClassPolicyMinimal,
 }
 
+// minimalClassData only writes the metrics of the pod to the logs of the
+// telegraf sidecar, so that the pod can start when its class is missing.
+const minimalClassData = `[[outputs.file]]
+  files = ["stdout"]
+`
+
+// replaceablePluginTypes are the types of plugins that can be configured with
+// pod annotations.
+var replaceablePluginTypes = []string{"inputs", "aggregators", "processors"}
+
 type prometheusInput struct {
-	Interval      string   `toml:"interval"`
-	Urls          []string `toml:"urls"`
-	Namepass      []string `toml:"namepass"`
-	MetricVersion uint8    `toml:"metric_version"`
+	Interval           string              `toml:"interval"`
+	Urls               []string            `toml:"urls"`
+	Namepass           []string            `toml:"namepass"`
+	Namedrop           []string            `toml:"namedrop,omitempty"`
+	Fieldpass          []string            `toml:"fieldpass,omitempty"`
+	Fielddrop          []string            `toml:"fielddrop,omitempty"`
+	Taginclude         []string            `toml:"taginclude,omitempty"`
+	Tagexclude         []string            `toml:"tagexclude,omitempty"`
+	Tagpass            map[string][]string `toml:"tagpass,omitempty"`
+	Tagdrop            map[string][]string `toml:"tagdrop,omitempty"`
+	MetricVersion      uint8               `toml:"metric_version"`
+	Timeout            string              `toml:"timeout,omitempty"`
+	Headers            map[string]string   `toml:"http_headers,omitempty"`
+	BearerToken        string              `toml:"bearer_token,omitempty"`
+	Username           string              `toml:"username,omitempty"`
+	Password           string              `toml:"password,omitempty"`
+	TLSCA              string              `toml:"tls_ca,omitempty"`
+	TLSCert            string              `toml:"tls_cert,omitempty"`
+	TLSKey             string              `toml:"tls_key,omitempty"`
+	TLSServerName      string              `toml:"tls_server_name,omitempty"`
+	InsecureSkipVerify bool                `toml:"insecure_skip_verify,omitempty"`
+}
+
+// metricFilters are the metric filtering settings of the pod annotations.
+type metricFilters struct {
+	namepass   []string
+	namedrop   []string
+	fieldpass  []string
+	fielddrop  []string
+	taginclude []string
+	tagexclude []string
+	tagpass    map[string][]string
+	tagdrop    map[string][]string
+}
+
+// scrapeAuth are the paths of the files in the scrape auth volume of the
+// sidecar, and the references to its basic auth environment variables.
+type scrapeAuth struct {
+	bearerToken   string
+	username      string
+	password      string
+	tlsCA         string
+	tlsCert       string
+	tlsKey        string
+	tlsServerName string
+}
+
+// endpoint is an endpoint of the endpoints annotation. Unset settings default
+// to the values of the other telegraf annotations.
+type endpoint struct {
+	Port          uint16            `json:"port"`
+	Path          string            `json:"path,omitempty"`
+	Scheme        string            `json:"scheme,omitempty"`
+	Interval      string            `json:"interval,omitempty"`
+	MetricVersion uint8             `json:"metric_version,omitempty"`
+	Timeout       string            `json:"timeout,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Namepass      []string          `json:"namepass,omitempty"`
+	Namedrop      []string          `json:"namedrop,omitempty"`
 }
 
 type telegrafConfig struct {
@@ -70,15 +194,16 @@ type rawInputs struct {
 }
 
 // newAnnotationValues returns a pointer to a new annotationValues with default values initialized.
-func newAnnotationValues(classDataHandler classdata.Handler, class string, enableInternal bool) *annotationValues {
+func newAnnotationValues(classDataHandler classdata.Handler, pod *corev1.Pod, class string,
+	enableInternal bool) *annotationValues {
 	return &annotationValues{
 		classDataHandler: classDataHandler,
+		pod:              pod,
 		class:            class,
 		metricsPath:      "/metrics",
 		ports:            []uint16{},
 		scheme:           "http",
 		metricVersion:    1,
-		namepass:         "",
 		interval:         defaultInterval,
 		enableInternal:   enableInternal,
 		rawInput:         "",
@@ -100,6 +225,12 @@ func (c *annotationValues) applyAnnotationOverrides(annotations map[string]strin
 		c.class = override
 	}
 
+	// The telegraf annotations below take precedence over the prometheus.io
+	// annotations.
+	if featuregate.PrometheusAnnotations.IsEnabled() && metadata.PrometheusScrapeEnabled(annotations) {
+		warnings = append(warnings, c.applyPrometheusAnnotations(annotations)...)
+	}
+
 	//nolint:staticcheck
 	if override, ok := annotations[metadata.TelegrafConfigMetricsPortAnnotation]; ok {
 		warnings = append(warnings, fmt.Sprintf("Deprecated: %s will be removed in a future version, use %s instead.",
@@ -134,9 +265,7 @@ func (c *annotationValues) applyAnnotationOverrides(annotations map[string]strin
 		c.scheme = override
 	}
 
-	if override, ok := annotations[metadata.TelegrafConfigMetricsNamepass]; ok {
-		c.namepass = strings.ReplaceAll(strings.Trim(override, "[]"), "'", "")
-	}
+	warnings = append(warnings, c.applyMetricFilterAnnotations(annotations)...)
 
 	if override, ok := annotations[metadata.TelegrafConfigMetricVersionAnnotation]; ok {
 		if ver, err := strconv.ParseUint(override, 10, 8); err != nil {
@@ -156,6 +285,12 @@ func (c *annotationValues) applyAnnotationOverrides(annotations map[string]strin
 		}
 	}
 
+	if override, ok := annotations[metadata.TelegrafConfigEndpointsAnnotation]; ok {
+		warnings = append(warnings, c.parseEndpoints(override)...)
+	}
+
+	warnings = append(warnings, c.applyScrapeAuthAnnotations(annotations)...)
+
 	if override, ok := annotations[metadata.TelegrafConfigEnableInternalAnnotation]; ok {
 		if override != "" {
 			c.enableInternal = true
@@ -174,6 +309,23 @@ func (c *annotationValues) applyAnnotationOverrides(annotations map[string]strin
 		c.rawProcessors = override
 	}
 
+	if override, ok := annotations[metadata.TelegrafConfigReplacePluginsAnnotation]; ok {
+		for _, plugin := range strings.Split(override, ",") {
+			plugin = strings.TrimSpace(plugin)
+			if plugin == "" {
+				continue
+			}
+			pluginType, _, _ := strings.Cut(plugin, ".")
+			if !slices.Contains(replaceablePluginTypes, pluginType) {
+				warnings = append(warnings, fmt.Sprintf("invalid plugin: %s for %s, must be one of [ %s ] or a plugin of one "+
+					"of these types, e.g. inputs.cpu", plugin, metadata.TelegrafConfigReplacePluginsAnnotation,
+					strings.Join(replaceablePluginTypes, ", ")))
+				continue
+			}
+			c.replacePlugins = append(c.replacePlugins, plugin)
+		}
+	}
+
 	c.globalTags = metadata.GetAnnotationsWithPrefix(annotations,
 		metadata.TelegrafConfigGlobalTagLiteralPrefixAnnotation)
 
@@ -185,42 +337,313 @@ func (c *annotationValues) applyAnnotationOverrides(annotations map[string]strin
 	return nil
 }
 
+// applyMetricFilterAnnotations sets the metric filters of the filter
+// annotations. The tag filters map tag names to lists of glob patterns, and
+// are given as JSON or YAML.
+func (c *annotationValues) applyMetricFilterAnnotations(annotations map[string]string) []string {
+	for _, filter := range []struct {
+		annotation string
+		value      *[]string
+	}{
+		{metadata.TelegrafConfigMetricsNamepass, &c.filters.namepass},
+		{metadata.TelegrafConfigMetricsNamedrop, &c.filters.namedrop},
+		{metadata.TelegrafConfigMetricsFieldpass, &c.filters.fieldpass},
+		{metadata.TelegrafConfigMetricsFielddrop, &c.filters.fielddrop},
+		{metadata.TelegrafConfigMetricsTaginclude, &c.filters.taginclude},
+		{metadata.TelegrafConfigMetricsTagexclude, &c.filters.tagexclude},
+	} {
+		if override, ok := annotations[filter.annotation]; ok {
+			*filter.value = parseFilterList(override)
+		}
+	}
+
+	var warnings []string
+	for _, filter := range []struct {
+		annotation string
+		value      *map[string][]string
+	}{
+		{metadata.TelegrafConfigMetricsTagpass, &c.filters.tagpass},
+		{metadata.TelegrafConfigMetricsTagdrop, &c.filters.tagdrop},
+	} {
+		override, ok := annotations[filter.annotation]
+		if !ok {
+			continue
+		}
+		var value map[string][]string
+		if err := yaml.UnmarshalStrict([]byte(override), &value); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to parse value for %s, error: %s",
+				filter.annotation, err.Error()))
+			continue
+		}
+		*filter.value = value
+	}
+
+	return warnings
+}
+
+// parseFilterList parses a comma-separated list of glob patterns, which may be
+// wrapped in brackets and single quotes like a TOML array, e.g. "['a', 'b']".
+func parseFilterList(value string) []string {
+	var patterns []string
+	value = strings.ReplaceAll(strings.Trim(value, "[]"), "'", "")
+	if value == "" {
+		return nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		patterns = append(patterns, strings.TrimSpace(item))
+	}
+
+	return patterns
+}
+
+// parseEndpoints parses the endpoints annotation, skipping invalid endpoints.
+func (c *annotationValues) parseEndpoints(value string) []string {
+	var endpoints []endpoint
+	if err := yaml.UnmarshalStrict([]byte(value), &endpoints); err != nil {
+		return []string{fmt.Sprintf("failed to parse value for %s, error: %s",
+			metadata.TelegrafConfigEndpointsAnnotation, err.Error())}
+	}
+
+	var warnings []string
+	for i, e := range endpoints {
+		var invalid []string
+		if e.Port == 0 {
+			invalid = append(invalid, "port is required")
+		}
+		if e.Scheme != "" && e.Scheme != "http" && e.Scheme != "https" {
+			invalid = append(invalid, fmt.Sprintf("scheme: %s must be http or https", e.Scheme))
+		}
+		if e.MetricVersion > 2 {
+			invalid = append(invalid, fmt.Sprintf("metric_version: %d must be 1 or 2", e.MetricVersion))
+		}
+		for name, duration := range map[string]string{"interval": e.Interval, "timeout": e.Timeout} {
+			if _, err := time.ParseDuration(duration); duration != "" && err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s: %s must be a duration", name, duration))
+			}
+		}
+		if len(invalid) > 0 {
+			slices.Sort(invalid)
+			warnings = append(warnings, fmt.Sprintf("invalid endpoint: %d for %s, %s", i,
+				metadata.TelegrafConfigEndpointsAnnotation, strings.Join(invalid, ", ")))
+			continue
+		}
+		c.endpoints = append(c.endpoints, e)
+	}
+
+	return warnings
+}
+
+// applyScrapeAuthAnnotations sets the TLS and authentication settings of the
+// scrape auth annotations. The files and environment variables they refer to
+// are only added to the sidecar when the pod is created, so the annotations
+// are ignored if the sidecar doesn't have them. The webhook only logs the
+// references it can't parse, they are reported here instead.
+func (c *annotationValues) applyScrapeAuthAnnotations(annotations map[string]string) []string {
+	var warnings []string
+	c.scrapeAuth.tlsServerName = annotations[metadata.TelegrafConfigTLSServerNameAnnotation]
+
+	keyRefs := []string{
+		metadata.TelegrafConfigTLSCASecretAnnotation,
+		metadata.TelegrafConfigTLSCAConfigMapAnnotation,
+		metadata.TelegrafConfigBearerTokenSecretAnnotation,
+	}
+	projected := c.scrapeAuthFiles()
+	file := func(annotation, name string) string {
+		value, ok := annotations[annotation]
+		if !ok {
+			return ""
+		}
+		if _, _, valid := metadata.ParseKeyRef(value); slices.Contains(keyRefs, annotation) && !valid {
+			warnings = append(warnings, fmt.Sprintf("invalid value: %s for %s, must be a reference in the "+
+				"format <name>/<key>", value, annotation))
+			return ""
+		}
+		if value == "" {
+			warnings = append(warnings, fmt.Sprintf("invalid value for %s, must be the name of a secret", annotation))
+			return ""
+		}
+		if !slices.Contains(projected, name) {
+			warnings = append(warnings, fmt.Sprintf("%s is ignored, the pod has to be recreated to mount it into "+
+				"the sidecar", annotation))
+			return ""
+		}
+		return path.Join(metadata.ScrapeAuthMountPath, name)
+	}
+
+	// As in the webhook, the secret annotations take precedence over the
+	// ConfigMap and service account token annotations, which are ignored
+	// rather than used when the secret reference is invalid.
+	if _, ok := annotations[metadata.TelegrafConfigTLSCASecretAnnotation]; ok {
+		c.scrapeAuth.tlsCA = file(metadata.TelegrafConfigTLSCASecretAnnotation, metadata.ScrapeAuthCAFile)
+	} else {
+		c.scrapeAuth.tlsCA = file(metadata.TelegrafConfigTLSCAConfigMapAnnotation, metadata.ScrapeAuthCAFile)
+	}
+	if cert := file(metadata.TelegrafConfigTLSCertSecretAnnotation, metadata.ScrapeAuthCertFile); cert != "" {
+		c.scrapeAuth.tlsCert = cert
+		c.scrapeAuth.tlsKey = path.Join(metadata.ScrapeAuthMountPath, metadata.ScrapeAuthKeyFile)
+	}
+	if _, ok := annotations[metadata.TelegrafConfigBearerTokenSecretAnnotation]; ok {
+		c.scrapeAuth.bearerToken = file(metadata.TelegrafConfigBearerTokenSecretAnnotation, metadata.ScrapeAuthTokenFile)
+	} else if annotations[metadata.TelegrafConfigBearerTokenServiceAccountAnnotation] == "true" {
+		c.scrapeAuth.bearerToken = file(metadata.TelegrafConfigBearerTokenServiceAccountAnnotation,
+			metadata.ScrapeAuthTokenFile)
+	}
+
+	if name, ok := annotations[metadata.TelegrafConfigBasicAuthSecretAnnotation]; ok {
+		switch {
+		case name == "":
+			warnings = append(warnings, fmt.Sprintf("invalid value for %s, must be the name of a secret",
+				metadata.TelegrafConfigBasicAuthSecretAnnotation))
+		case c.hasContainerEnv(metadata.ScrapeUsernameEnvVar):
+			c.scrapeAuth.username = "${" + metadata.ScrapeUsernameEnvVar + "}"
+			c.scrapeAuth.password = "${" + metadata.ScrapePasswordEnvVar + "}"
+		default:
+			warnings = append(warnings, fmt.Sprintf("%s is ignored, the pod has to be recreated to add it to the "+
+				"sidecar", metadata.TelegrafConfigBasicAuthSecretAnnotation))
+		}
+	}
+
+	return warnings
+}
+
+// scrapeAuthFiles returns the files projected into the scrape auth volume of
+// the pod.
+func (c *annotationValues) scrapeAuthFiles() []string {
+	var files []string
+	for _, volume := range c.pod.Spec.Volumes {
+		if volume.Name != metadata.ScrapeAuthVolumeName || volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			switch {
+			case source.Secret != nil:
+				for _, item := range source.Secret.Items {
+					files = append(files, item.Path)
+				}
+			case source.ConfigMap != nil:
+				for _, item := range source.ConfigMap.Items {
+					files = append(files, item.Path)
+				}
+			case source.ServiceAccountToken != nil:
+				files = append(files, source.ServiceAccountToken.Path)
+			}
+		}
+	}
+
+	return files
+}
+
+// hasContainerEnv returns whether a container of the pod, which is the
+// telegraf sidecar for the variables added by the webhook, has the environment
+// variable name.
+func (c *annotationValues) hasContainerEnv(name string) bool {
+	for _, containers := range [][]corev1.Container{c.pod.Spec.Containers, c.pod.Spec.InitContainers} {
+		for _, container := range containers {
+			if slices.ContainsFunc(container.Env, func(env corev1.EnvVar) bool { return env.Name == name }) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// applyPrometheusAnnotations maps the prometheus.io scrape annotations onto
+// the ports, path and scheme of the Prometheus input plugin. The port is only
+// used if the pod doesn't set telegraf ports, so that it isn't scraped twice.
+func (c *annotationValues) applyPrometheusAnnotations(annotations map[string]string) []string {
+	var warnings []string
+
+	_, hasPort := annotations[metadata.TelegrafConfigMetricsPortAnnotation] //nolint:staticcheck
+	_, hasPorts := annotations[metadata.TelegrafConfigMetricsPortsAnnotation]
+	if override, ok := annotations[metadata.PrometheusPortAnnotation]; ok && !hasPort && !hasPorts {
+		if port, err := strconv.ParseUint(strings.TrimSpace(override), 10, 16); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to convert value: %s for %s to integer, error: %s",
+				override, metadata.PrometheusPortAnnotation, err.Error()))
+		} else {
+			c.ports = append(c.ports, uint16(port))
+		}
+	} else if !ok && !hasPort && !hasPorts {
+		warnings = append(warnings, fmt.Sprintf("%s is set without %s, there is no port to scrape",
+			metadata.PrometheusScrapeAnnotation, metadata.PrometheusPortAnnotation))
+	}
+
+	if override, ok := annotations[metadata.PrometheusPathAnnotation]; ok {
+		c.metricsPath = override
+	}
+
+	if override, ok := annotations[metadata.PrometheusSchemeAnnotation]; ok {
+		c.scheme = override
+	}
+
+	return warnings
+}
+
 func (c *annotationValues) buildConfigData() (string, error) {
 	cfg := telegrafConfig{
 		Inputs:     map[string]any{},
 		GlobalTags: map[string]string{},
 	}
 
-	classData, ok := c.classDataHandler.GetDataForClass(c.class)
-	if !ok {
-		return "", fmt.Errorf("failed to get class data: %s, class name doesn't exist", c.class)
+	classes := metadata.SplitClasses(c.class)
+	if len(classes) == 0 {
+		return "", fmt.Errorf("failed to get class data, no class is set")
 	}
-
-	if err := toml.Unmarshal(classData, &cfg); err != nil {
-		return "", fmt.Errorf("failed to unmarshal class data, error: %w", err)
+	for _, class := range classes {
+		if !metadata.ValidClassName(class) {
+			return "", fmt.Errorf("failed to get class data: %s, class names can't contain a slash", class)
+		}
 	}
 
-	if len(c.ports) > 0 {
-		var promCfg prometheusInput
-
-		for _, port := range c.ports {
-			promCfg.Urls = append(promCfg.Urls, fmt.Sprintf("%s://localhost:%d%s", c.scheme, port, c.metricsPath))
+	// Classes are merged in order, see telegrafConfig.merge. The class hash
+	// covers the data of all classes, and is the hash of the class data for a
+	// single class.
+	classesData := make([][]byte, 0, len(classes))
+	c.canary = false
+	c.namespacedClasses = nil
+	c.agentConflicts = nil
+	c.missingClasses = nil
+	for _, class := range classes {
+		classData, canary, ok := lookupClass(c.classDataHandler, c.pod.GetNamespace(), c.pod.GetUID(), class)
+		if !ok {
+			c.missingClasses = append(c.missingClasses, class)
+			var err error
+			if classData, canary, err = c.missingClassData(class); err != nil {
+				return "", err
+			}
+		}
+		classesData = append(classesData, classData)
+		c.canary = c.canary || canary
+		if ok && isNamespacedClass(c.classDataHandler, c.pod.GetNamespace(), class) {
+			c.namespacedClasses = append(c.namespacedClasses, class)
 		}
 
-		promCfg.Interval = c.interval.String()
-		promCfg.MetricVersion = c.metricVersion
-
-		if c.namepass != "" {
-			namepass := strings.Split(c.namepass, ",")
-			for _, item := range namepass {
-				promCfg.Namepass = append(promCfg.Namepass, strings.TrimSpace(item))
+		if featuregate.ClassTemplates.IsEnabled() && classdata.IsTemplate(classData) {
+			var err error
+			if classData, err = renderClassTemplate(class, classData, newClassTemplateData(c.pod)); err != nil {
+				return "", err
 			}
 		}
 
-		cfg.Inputs["prometheus"] = []prometheusInput{promCfg}
+		var classCfg telegrafConfig
+		if err := toml.Unmarshal(classData, &classCfg); err != nil {
+			return "", fmt.Errorf("failed to unmarshal class data: %s, error: %w", class, err)
+		}
+		for _, key := range cfg.merge(classCfg) {
+			c.agentConflicts = append(c.agentConflicts, fmt.Sprintf("%s overridden by class: %s", key, class))
+		}
+	}
+	c.classHash = contentHash(bytes.Join(classesData, []byte("\n")))
+
+	// The inputs are added at once, so that replacing the prometheus input
+	// of the classes doesn't replace the inputs of the annotations.
+	if promCfgs := c.prometheusInputs(); len(promCfgs) > 0 {
+		cfg.Inputs = c.addPlugins(cfg.Inputs, "inputs", map[string]any{"prometheus": promCfgs})
 	}
 
-	if c.enableInternal {
+	// The internal plugin can be enabled for all pods, it isn't added a second
+	// time to classes defining it.
+	if _, ok := cfg.Inputs["internal"]; c.enableInternal && (!ok || c.replaces("inputs", "internal")) {
 		cfg.Inputs["internal"] = []map[string]any{make(map[string]any)}
 	}
 
@@ -230,9 +653,7 @@ func (c *annotationValues) buildConfigData() (string, error) {
 			return "", fmt.Errorf("failed to unmarshal raw input annotation data, error: %w", err)
 		}
 
-		for k, v := range rawInputs.Inputs {
-			cfg.Inputs[k] = v
-		}
+		cfg.Inputs = c.addPlugins(cfg.Inputs, "inputs", rawInputs.Inputs)
 	}
 
 	if c.rawAggregators != "" && featuregate.AggregatorAnnotations.IsEnabled() {
@@ -245,12 +666,7 @@ func (c *annotationValues) buildConfigData() (string, error) {
 			return "", fmt.Errorf("failed to unmarshal raw aggregators annotation data, error: %w", err)
 		}
 
-		if cfg.Aggregators == nil {
-			cfg.Aggregators = make(map[string]any)
-		}
-		for k, v := range rawAggs.Aggregators {
-			cfg.Aggregators[k] = v
-		}
+		cfg.Aggregators = c.addPlugins(cfg.Aggregators, "aggregators", rawAggs.Aggregators)
 	}
 
 	if c.rawProcessors != "" && featuregate.ProcessorAnnotations.IsEnabled() {
@@ -263,12 +679,7 @@ func (c *annotationValues) buildConfigData() (string, error) {
 			return "", fmt.Errorf("failed to unmarshal raw processors annotation data, error: %w", err)
 		}
 
-		if cfg.Processors == nil {
-			cfg.Processors = make(map[string]any)
-		}
-		for k, v := range rawProcs.Processors {
-			cfg.Processors[k] = v
-		}
+		cfg.Processors = c.addPlugins(cfg.Processors, "processors", rawProcs.Processors)
 	}
 
 	if len(c.globalTags) > 0 {
@@ -284,3 +695,261 @@ func (c *annotationValues) buildConfigData() (string, error) {
 
 	return string(config), nil
 }
+
+// merge merges the configuration of a class into c. Plugins are appended to the
+// plugins of the previous classes, while agent settings and global tags of the
+// class override the previous ones. The agent settings that are overridden with
+// a different value are returned.
+func (c *telegrafConfig) merge(src telegrafConfig) []string {
+	var conflicts []string
+	if src.Agent != nil && c.Agent == nil {
+		c.Agent = make(map[string]any)
+	}
+	for _, key := range slices.Sorted(maps.Keys(src.Agent)) {
+		if prev, ok := c.Agent[key]; ok && !reflect.DeepEqual(prev, src.Agent[key]) {
+			conflicts = append(conflicts, key)
+		}
+		c.Agent[key] = src.Agent[key]
+	}
+
+	c.Inputs = appendPlugins(c.Inputs, src.Inputs)
+	c.Outputs = appendPlugins(c.Outputs, src.Outputs)
+	c.Aggregators = appendPlugins(c.Aggregators, src.Aggregators)
+	c.Processors = appendPlugins(c.Processors, src.Processors)
+
+	if src.GlobalTags != nil && c.GlobalTags == nil {
+		c.GlobalTags = make(map[string]string)
+	}
+	maps.Copy(c.GlobalTags, src.GlobalTags)
+
+	return conflicts
+}
+
+// appendPlugins appends the plugin arrays in src to the arrays of the same
+// plugins in dst.
+func appendPlugins(dst, src map[string]any) map[string]any {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]any, len(src))
+	}
+
+	for name, plugins := range src {
+		dst[name] = appendPluginArray(dst[name], plugins)
+	}
+
+	return dst
+}
+
+// prometheusInputs returns the Prometheus inputs scraping the ports, the
+// endpoints and the monitor targets of the pod. Endpoints with the same
+// settings are scraped by a single input.
+func (c *annotationValues) prometheusInputs() []prometheusInput {
+	defaults := prometheusInput{
+		Interval:      c.interval.String(),
+		Namepass:      c.filters.namepass,
+		Namedrop:      c.filters.namedrop,
+		Fieldpass:     c.filters.fieldpass,
+		Fielddrop:     c.filters.fielddrop,
+		Taginclude:    c.filters.taginclude,
+		Tagexclude:    c.filters.tagexclude,
+		Tagpass:       c.filters.tagpass,
+		Tagdrop:       c.filters.tagdrop,
+		MetricVersion: c.metricVersion,
+		BearerToken:   c.scrapeAuth.bearerToken,
+		Username:      c.scrapeAuth.username,
+		Password:      c.scrapeAuth.password,
+		TLSCA:         c.scrapeAuth.tlsCA,
+		TLSCert:       c.scrapeAuth.tlsCert,
+		TLSKey:        c.scrapeAuth.tlsKey,
+		TLSServerName: c.scrapeAuth.tlsServerName,
+	}
+
+	var inputs []prometheusInput
+	for _, port := range c.ports {
+		// A port of the endpoints annotation is only scraped with the settings
+		// of its endpoint.
+		if slices.ContainsFunc(c.endpoints, func(e endpoint) bool { return e.Port == port }) {
+			continue
+		}
+		input := defaults
+		input.Urls = []string{fmt.Sprintf("%s://localhost:%d%s", c.scheme, port, c.metricsPath)}
+		inputs = append(inputs, input)
+	}
+
+	for _, e := range c.endpoints {
+		input := defaults
+		scheme, path := cmp.Or(e.Scheme, c.scheme), cmp.Or(e.Path, c.metricsPath)
+		input.Urls = []string{fmt.Sprintf("%s://localhost:%d%s", scheme, e.Port, path)}
+		input.Interval = cmp.Or(e.Interval, input.Interval)
+		input.MetricVersion = cmp.Or(e.MetricVersion, input.MetricVersion)
+		input.Timeout = e.Timeout
+		input.Headers = e.Headers
+		if e.Namepass != nil {
+			input.Namepass = e.Namepass
+		}
+		if e.Namedrop != nil {
+			input.Namedrop = e.Namedrop
+		}
+		inputs = append(inputs, input)
+	}
+
+	// The monitor targets have their own TLS settings, and aren't scraped
+	// with the scrape auth annotations.
+	for _, target := range c.monitorTargets {
+		inputs = append(inputs, prometheusInput{
+			Interval:           cmp.Or(target.Interval, defaults.Interval),
+			Urls:               []string{target.URL()},
+			Namepass:           target.Namepass,
+			Namedrop:           target.Namedrop,
+			MetricVersion:      defaults.MetricVersion,
+			TLSServerName:      target.ServerName,
+			InsecureSkipVerify: target.InsecureSkipVerify,
+		})
+	}
+
+	return groupPrometheusInputs(inputs)
+}
+
+// groupPrometheusInputs merges the URLs of inputs with the same settings into
+// the first of them.
+func groupPrometheusInputs(inputs []prometheusInput) []prometheusInput {
+	var grouped []prometheusInput
+	index := make(map[string]int)
+	for _, input := range inputs {
+		settings := input
+		settings.Urls = nil
+		key := fmt.Sprintf("%#v", settings)
+		if i, ok := index[key]; ok {
+			grouped[i].Urls = append(grouped[i].Urls, input.Urls...)
+			continue
+		}
+		index[key] = len(grouped)
+		grouped = append(grouped, input)
+	}
+
+	return grouped
+}
+
+// addPlugins adds the plugins of type pluginType configured by pod annotations
+// to the plugins of the classes. Plugin arrays are appended to, unless the pod
+// replaces the plugin with the replace-plugins annotation.
+func (c *annotationValues) addPlugins(dst map[string]any, pluginType string, src map[string]any) map[string]any {
+	if dst == nil {
+		dst = make(map[string]any, len(src))
+	}
+
+	for name, plugins := range src {
+		if c.replaces(pluginType, name) {
+			dst[name] = plugins
+			continue
+		}
+		dst[name] = appendPluginArray(dst[name], plugins)
+	}
+
+	return dst
+}
+
+// replaces returns whether the pod annotations replace the plugin of the
+// classes, either by naming the plugin, e.g. inputs.cpu, or its type.
+func (c *annotationValues) replaces(pluginType, name string) bool {
+	return slices.Contains(c.replacePlugins, pluginType) || slices.Contains(c.replacePlugins, pluginType+"."+name)
+}
+
+// appendPluginArray returns the plugin array next appended to prev. Plugin
+// arrays are decoded as []map[string]any, while the plugins generated from pod
+// annotations are structs, so arrays of different types are appended as []any.
+// A value that isn't a plugin array replaces the previous value.
+func appendPluginArray(prev, next any) any {
+	if prev == nil {
+		return next
+	}
+	p, n := reflect.ValueOf(prev), reflect.ValueOf(next)
+	if p.Kind() != reflect.Slice || n.Kind() != reflect.Slice {
+		return next
+	}
+
+	if p.Type() == n.Type() {
+		merged := reflect.MakeSlice(p.Type(), 0, p.Len()+n.Len())
+		return reflect.AppendSlice(reflect.AppendSlice(merged, p), n).Interface()
+	}
+
+	merged := make([]any, 0, p.Len()+n.Len())
+	for i := range p.Len() {
+		merged = append(merged, p.Index(i).Interface())
+	}
+	for i := range n.Len() {
+		merged = append(merged, n.Index(i).Interface())
+	}
+
+	return merged
+}
+
+// missingClassData returns the data a class that doesn't exist is rendered
+// with, according to the missing class policy.
+func (c *annotationValues) missingClassData(class string) ([]byte, bool, error) {
+	switch c.missingClassPolicy {
+	case MissingClassPolicyDefault:
+		data, canary, ok := lookupClass(c.classDataHandler, c.pod.GetNamespace(), c.pod.GetUID(), c.fallbackClass)
+		if !ok {
+			return nil, false, fmt.Errorf("failed to get class data: %s, class name doesn't exist, "+
+				"and neither does default class: %s", class, c.fallbackClass)
+		}
+		return data, canary, nil
+	case MissingClassPolicyMinimal:
+		return []byte(minimalClassData), false, nil
+	default:
+		return nil, false, fmt.Errorf("failed to get class data: %s, class name doesn't exist", class)
+	}
+}
+
+// lookupClass returns the data of a class for a pod in namespace, preferring a
+// namespaced class over a cluster class, and whether the data is the canary
+// revision of the class.
+func lookupClass(handler classdata.Handler, namespace string, uid types.UID, class string) ([]byte, bool, bool) {
+	if !metadata.ValidClassName(class) {
+		return nil, false, false
+	}
+
+	name := class
+	if isNamespacedClass(handler, namespace, class) {
+		name = classdata.NamespacedClassName(namespace, class)
+	}
+	data, ok := handler.GetDataForClass(name)
+	if !ok {
+		return nil, false, false
+	}
+
+	// Only the canary revision of the class that was found is used, a cluster
+	// canary class doesn't apply to pods using a namespaced class.
+	if canaryData, ok := handler.GetDataForClass(name + classdata.CanarySuffix); ok &&
+		inCanary(uid, classdata.CanaryWeight(canaryData)) {
+		return canaryData, true, true
+	}
+
+	return data, false, true
+}
+
+// isNamespacedClass returns whether class is defined in namespace, which takes
+// precedence over a cluster class of the same name.
+func isNamespacedClass(handler classdata.Handler, namespace, class string) bool {
+	_, ok := handler.GetDataForClass(classdata.NamespacedClassName(namespace, class))
+	return ok
+}
+
+// inCanary returns whether a pod is one of the given percentage of pods that a
+// canary revision is rolled out to. Pods are assigned to a bucket by their UID,
+// so a pod stays on the canary revision when the percentage is raised.
+func inCanary(uid types.UID, weight int) bool {
+	h := fnv.New32a()
+	h.Write([]byte(uid))
+
+	return int(h.Sum32()%100) < weight
+}
+
+// contentHash returns a short hex encoded SHA-256 hash of data.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:contentHashLength]
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/jmickey/telegraf-sidecar-operator/api/v1alpha1"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+)
+
+// TelegrafClassReconciler maintains the status of TelegrafClass and
+// TelegrafNamespaceClass objects. The pods using a class are counted with the
+// secret index of the PodReconciler, which must be set up with the same
+// manager.
+type TelegrafClassReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=telegraf.mickey.dev,resources=telegrafclasses;telegrafnamespaceclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=telegraf.mickey.dev,resources=telegrafclasses/status;telegrafnamespaceclasses/status,verbs=get;update;patch
+
+// SetupWithManager sets up the controllers for both class kinds with the Manager.
+func (r *TelegrafClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := ctrl.NewControllerManagedBy(mgr).
+		Named("telegrafclass").
+		For(&v1alpha1.TelegrafClass{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret(false))).
+		Complete(reconcile.Func(r.reconcileClass))
+	if err != nil {
+		return fmt.Errorf("failed to create TelegrafClass controller: %w", err)
+	}
+
+	err = ctrl.NewControllerManagedBy(mgr).
+		Named("telegrafnamespaceclass").
+		For(&v1alpha1.TelegrafNamespaceClass{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret(true))).
+		Complete(reconcile.Func(r.reconcileNamespaceClass))
+	if err != nil {
+		return fmt.Errorf("failed to create TelegrafNamespaceClass controller: %w", err)
+	}
+
+	return nil
+}
+
+// requestsForSecret maps a telegraf config secret to the classes of the kind
+// it was rendered from, so that the number of pods using a class is kept up to
+// date.
+func (r *TelegrafClassReconciler) requestsForSecret(namespaced bool) handler.MapFunc {
+	return func(_ context.Context, obj client.Object) []reconcile.Request {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, class := range secretClassNames(secret) {
+			name := types.NamespacedName{Name: class}
+			if namespace, class, ok := strings.Cut(class, "/"); ok {
+				name = types.NamespacedName{Namespace: namespace, Name: class}
+			}
+			if (name.Namespace != "") == namespaced {
+				requests = append(requests, reconcile.Request{NamespacedName: name})
+			}
+		}
+
+		return requests
+	}
+}
+
+func (r *TelegrafClassReconciler) reconcileClass(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	class := &v1alpha1.TelegrafClass{}
+	if err := r.Get(ctx, req.NamespacedName, class); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	status, err := r.buildStatus(ctx, class.Spec.Config, class.Status, class.GetGeneration(), class.GetName(),
+		class.GetName())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if equality.Semantic.DeepEqual(status, class.Status) {
+		return ctrl.Result{}, nil
+	}
+
+	class.Status = status
+	return ctrl.Result{}, r.updateStatus(ctx, class)
+}
+
+func (r *TelegrafClassReconciler) reconcileNamespaceClass(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	class := &v1alpha1.TelegrafNamespaceClass{}
+	if err := r.Get(ctx, req.NamespacedName, class); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	status, err := r.buildStatus(ctx, class.Spec.Config, class.Status, class.GetGeneration(),
+		class.GetName(), classdata.NamespacedClassName(class.GetNamespace(), class.GetName()),
+		client.InNamespace(class.GetNamespace()))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if equality.Semantic.DeepEqual(status, class.Status) {
+		return ctrl.Result{}, nil
+	}
+
+	class.Status = status
+	return ctrl.Result{}, r.updateStatus(ctx, class)
+}
+
+// buildStatus returns the status of the class name, which is named indexName in
+// the secret class index.
+func (r *TelegrafClassReconciler) buildStatus(ctx context.Context, config string, current v1alpha1.TelegrafClassStatus,
+	generation int64, name, indexName string, opts ...client.ListOption) (v1alpha1.TelegrafClassStatus, error) {
+	status := *current.DeepCopy()
+	status.ObservedGeneration = generation
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionTypeValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ConfigParsed",
+		Message:            "class configuration is valid",
+		ObservedGeneration: generation,
+	}
+	if err := classdata.Validate([]byte(config)); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidConfig"
+		condition.Message = fmt.Sprintf("failed to parse class configuration: %s", err.Error())
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	secrets := &corev1.SecretList{}
+	opts = append(opts, client.MatchingFields{secretClassesIndex: indexName})
+	if err := r.List(ctx, secrets, opts...); err != nil {
+		return status, fmt.Errorf("failed to list telegraf config secrets for class: %s, error: %w", name, err)
+	}
+	status.Pods = int32(len(secrets.Items))
+
+	return status, nil
+}
+
+func (r *TelegrafClassReconciler) updateStatus(ctx context.Context, obj client.Object) error {
+	if err := r.Status().Update(ctx, obj); err != nil {
+		return fmt.Errorf("failed to update status for class: %s, error: %w", obj.GetName(), err)
+	}
+
+	return nil
+}
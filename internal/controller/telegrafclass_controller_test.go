@@ -0,0 +1,177 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/jmickey/telegraf-sidecar-operator/api/v1alpha1"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("TelegrafClass Controller", func() {
+	When("A TelegrafClass is created", func() {
+		It("Should report valid configuration in the status", func() {
+			class := &v1alpha1.TelegrafClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "valid-class"},
+				Spec: v1alpha1.TelegrafClassSpec{
+					Config: "[[outputs.file]]\n  files = [\"stdout\"]\n",
+				},
+			}
+			Expect(k8sClient.Create(testCtx, class)).Should(Succeed())
+
+			Eventually(func() metav1.ConditionStatus {
+				c := &v1alpha1.TelegrafClass{}
+				Expect(k8sClient.Get(testCtx, types.NamespacedName{Name: class.GetName()}, c)).Should(Succeed())
+				cond := meta.FindStatusCondition(c.Status.Conditions, v1alpha1.ConditionTypeValid)
+				if cond == nil {
+					return metav1.ConditionUnknown
+				}
+				return cond.Status
+			}, timeout, interval).Should(Equal(metav1.ConditionTrue))
+
+			Expect(k8sClient.Delete(testCtx, class)).Should(Succeed())
+		})
+
+		It("Should report invalid configuration in the status", func() {
+			class := &v1alpha1.TelegrafClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "invalid-class"},
+				Spec: v1alpha1.TelegrafClassSpec{
+					Config: "[[outputs.file]",
+				},
+			}
+			Expect(k8sClient.Create(testCtx, class)).Should(Succeed())
+
+			Eventually(func() string {
+				c := &v1alpha1.TelegrafClass{}
+				Expect(k8sClient.Get(testCtx, types.NamespacedName{Name: class.GetName()}, c)).Should(Succeed())
+				cond := meta.FindStatusCondition(c.Status.Conditions, v1alpha1.ConditionTypeValid)
+				if cond == nil {
+					return ""
+				}
+				return cond.Reason
+			}, timeout, interval).Should(Equal("InvalidConfig"))
+
+			Expect(k8sClient.Delete(testCtx, class)).Should(Succeed())
+		})
+	})
+
+	// The secrets aren't labelled as managed by the operator, so that they
+	// aren't removed by the orphaned secret sweeper.
+	When("A TelegrafNamespaceClass is used by pods", func() {
+		It("Should count the pods using the class, including the pods using it with other classes", func() {
+			config := "[[outputs.file]]\n  files = [\"stdout\"]\n"
+			class := &v1alpha1.TelegrafNamespaceClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "counted-class", Namespace: namespace},
+				Spec:       v1alpha1.TelegrafClassSpec{Config: config},
+			}
+			Expect(k8sClient.Create(testCtx, class)).Should(Succeed())
+			clusterClass := &v1alpha1.TelegrafClass{
+				ObjectMeta: metav1.ObjectMeta{Name: class.GetName()},
+				Spec:       v1alpha1.TelegrafClassSpec{Config: config},
+			}
+			Expect(k8sClient.Create(testCtx, clusterClass)).Should(Succeed())
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "telegraf-config-counted-class",
+					Namespace: namespace,
+					Labels: map[string]string{
+						metadata.TelegrafSecretClassNameLabel: class.GetName(),
+					},
+					Annotations: map[string]string{
+						metadata.SecretNamespacedClassesAnnotation: class.GetName(),
+					},
+				},
+			}
+			Expect(k8sClient.Create(testCtx, secret)).Should(Succeed())
+			composedSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "telegraf-config-composed-counted-class",
+					Namespace: namespace,
+					Labels: map[string]string{
+						metadata.TelegrafSecretClassNameLabel: "testclass",
+					},
+					Annotations: map[string]string{
+						metadata.SecretComposedClassesAnnotation:   "testclass," + class.GetName(),
+						metadata.SecretNamespacedClassesAnnotation: class.GetName(),
+					},
+				},
+			}
+			Expect(k8sClient.Create(testCtx, composedSecret)).Should(Succeed())
+
+			Eventually(func() int32 {
+				c := &v1alpha1.TelegrafNamespaceClass{}
+				key := types.NamespacedName{Name: class.GetName(), Namespace: namespace}
+				Expect(k8sClient.Get(testCtx, key, c)).Should(Succeed())
+				return c.Status.Pods
+			}, timeout, interval).Should(Equal(int32(2)))
+
+			By("Not counting the pods using the namespaced class for the cluster class of the same name")
+			Consistently(func() int32 {
+				c := &v1alpha1.TelegrafClass{}
+				Expect(k8sClient.Get(testCtx, types.NamespacedName{Name: clusterClass.GetName()}, c)).Should(Succeed())
+				return c.Status.Pods
+			}, duration, interval).Should(Equal(int32(0)))
+
+			cleanUpSecret(secret.GetName())
+			cleanUpSecret(composedSecret.GetName())
+			Expect(k8sClient.Delete(testCtx, class)).Should(Succeed())
+			Expect(k8sClient.Delete(testCtx, clusterClass)).Should(Succeed())
+		})
+	})
+
+	When("A TelegrafClass is used by pods together with other classes", func() {
+		It("Should count the pods using the class", func() {
+			class := &v1alpha1.TelegrafClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "composed-counted-class"},
+				Spec: v1alpha1.TelegrafClassSpec{
+					Config: "[[outputs.file]]\n  files = [\"stdout\"]\n",
+				},
+			}
+			Expect(k8sClient.Create(testCtx, class)).Should(Succeed())
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "telegraf-config-composed-cluster-class",
+					Namespace: namespace,
+					Labels: map[string]string{
+						metadata.TelegrafSecretClassNameLabel: "testclass",
+					},
+					Annotations: map[string]string{
+						metadata.SecretComposedClassesAnnotation: "testclass," + class.GetName(),
+					},
+				},
+			}
+			Expect(k8sClient.Create(testCtx, secret)).Should(Succeed())
+
+			Eventually(func() int32 {
+				c := &v1alpha1.TelegrafClass{}
+				Expect(k8sClient.Get(testCtx, types.NamespacedName{Name: class.GetName()}, c)).Should(Succeed())
+				return c.Status.Pods
+			}, timeout, interval).Should(Equal(int32(1)))
+
+			cleanUpSecret(secret.GetName())
+			Expect(k8sClient.Delete(testCtx, class)).Should(Succeed())
+		})
+	})
+})
@@ -40,3 +40,53 @@ var AggregatorAnnotations = Register("telegraf.aggregators",
 var ProcessorAnnotations = Register("telegraf.processors",
 	"Enable telegraf processor plugin configuration via pod annotations",
 	false)
+
+// ConfigReadinessGate adds a readiness gate to injected pods that is only
+// satisfied once the telegraf configuration of the pod has been rendered.
+//
+// When enabled, the telegraf.influxdata.com/config-ready pod condition is set by
+// the controller, and rendering failures are reported through the condition.
+var ConfigReadinessGate = Register("operator.readinessgate",
+	"Add a telegraf config readiness gate to injected pods",
+	false)
+
+// ClassTemplates enables rendering class data as a Go template with the pod metadata.
+//
+// When enabled, classes setting template = true in their operator table can
+// reference the pod, e.g. {{ .Pod.Namespace }} or {{ index .Pod.Labels "app" }},
+// and the controlling owner of the pod, e.g. {{ .Owner.Name }}. Other classes are
+// used as is.
+var ClassTemplates = Register("telegraf.classtemplates",
+	"Enable rendering class data as a Go template with the pod metadata",
+	false)
+
+// ClassValidation enables semantic validation of classes when they are loaded.
+//
+// When enabled, classes must define at least one output plugin, may only use
+// known telegraf plugins, and may only reference environment variables that are
+// available to the sidecar. Classes failing validation are unavailable.
+var ClassValidation = Register("telegraf.classvalidation",
+	"Enable semantic validation of telegraf classes when they are loaded",
+	false)
+
+// PrometheusAnnotations enables the prometheus.io scrape annotations used by
+// many third-party charts.
+//
+// When enabled, pods with prometheus.io/scrape set to "true" are injected with
+// the telegraf sidecar, and prometheus.io/port, prometheus.io/path and
+// prometheus.io/scheme configure the Prometheus input plugin, unless the
+// equivalent telegraf.influxdata.com annotations are set.
+var PrometheusAnnotations = Register("telegraf.prometheusannotations",
+	"Enable the prometheus.io scrape annotations as an alternative to the telegraf port annotations",
+	false)
+
+// PodMonitors enables translating prometheus-operator PodMonitors and
+// ServiceMonitors into telegraf inputs.
+//
+// When enabled, pods in namespaces labelled with telegraf.influxdata.com/monitors
+// set to "true" that are selected by a PodMonitor or ServiceMonitor are injected
+// with the telegraf sidecar, and each endpoint of the monitors configures a
+// Prometheus input plugin.
+var PodMonitors = Register("operator.podmonitors",
+	"Enable translating prometheus-operator PodMonitors and ServiceMonitors into telegraf inputs",
+	false)
@@ -19,18 +19,25 @@ package injectorwebhook
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apiserver/pkg/storage/names"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classpolicy"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/config"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
 )
 
 type SidecarInjector struct {
@@ -48,6 +55,19 @@ type SidecarInjector struct {
 	SecurityAllowPrivilegeEscalation *config.OptionalBool
 	SecurityCapabilitiesAdd          string
 	SecurityCapabilitiesDrop         string
+	// ClassPolicy is enforced by rejecting pods when its action is reject. The
+	// Client is used to read the namespace of the pod, DefaultClass is the
+	// class of pods without a class annotation that don't match any of the
+	// class policy rules, and ClassDataHandler serves the classes, which are
+	// checked along with the classes they inherit from.
+	ClassPolicy      *classpolicy.Policy
+	Client           client.Reader
+	DefaultClass     string
+	ClassDataHandler classdata.Handler
+	// Monitors injects pods of opted in namespaces that are selected by a
+	// PodMonitor or ServiceMonitor, nil disables it. The Client is used to read
+	// the namespace of the pod.
+	Monitors *monitors.Source
 }
 
 //+kubebuilder:webhook:path=/mutate--v1-pod,mutating=true,failurePolicy=ignore,groups=core,resources=pods,verbs=create;update,versions=v1,name=telegraf.mickey.dev,sideEffects=none,admissionReviewVersions=v1
@@ -74,11 +94,21 @@ func (s *SidecarInjector) Default(ctx context.Context, obj runtime.Object) error
 
 	log = log.WithValues("podIdentifier", podIdentifier)
 
-	if !s.shouldInjectContainer(pod) {
+	if !s.shouldInjectContainer(ctx, pod) {
 		log.V(2).Info("skipping pod, telegraf sidecar injector should not handle it")
 		return nil
 	}
 
+	if err := checkClassNames(pod); err != nil {
+		log.Info("rejecting pod", "reason", err.Error())
+		return err
+	}
+
+	if err := s.checkClassPolicy(ctx, pod); err != nil {
+		log.Info("rejecting pod", "reason", err.Error())
+		return err
+	}
+
 	containerConfig, err := newContainerConfig(s)
 	if err != nil {
 		log.Error(err, "failed to initialize container configuration")
@@ -107,6 +137,15 @@ func (s *SidecarInjector) Default(ctx context.Context, obj runtime.Object) error
 		},
 	}
 	pod.Spec.Volumes = append(pod.Spec.Volumes, telegrafVol)
+	if scrapeAuthVol := containerConfig.buildScrapeAuthVolume(); scrapeAuthVol != nil {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, *scrapeAuthVol)
+	}
+
+	if featuregate.ConfigReadinessGate.IsEnabled() {
+		pod.Spec.ReadinessGates = append(pod.Spec.ReadinessGates, corev1.PodReadinessGate{
+			ConditionType: metadata.ConfigReadyConditionType,
+		})
+	}
 
 	if pod.Labels == nil {
 		pod.Labels = make(map[string]string)
@@ -118,7 +157,7 @@ func (s *SidecarInjector) Default(ctx context.Context, obj runtime.Object) error
 	return nil
 }
 
-func (s *SidecarInjector) shouldInjectContainer(pod *corev1.Pod) bool {
+func (s *SidecarInjector) shouldInjectContainer(ctx context.Context, pod *corev1.Pod) bool {
 	if s.hasTelegrafContainer(pod) {
 		return false
 	}
@@ -129,7 +168,130 @@ func (s *SidecarInjector) shouldInjectContainer(pod *corev1.Pod) bool {
 		}
 	}
 
-	return false
+	if featuregate.PrometheusAnnotations.IsEnabled() && metadata.PrometheusScrapeEnabled(pod.GetAnnotations()) {
+		return true
+	}
+
+	return s.selectedByMonitor(ctx, pod)
+}
+
+// selectedByMonitor returns whether the namespace of the pod opted in to
+// monitors, and the pod is selected by a PodMonitor or ServiceMonitor.
+func (s *SidecarInjector) selectedByMonitor(ctx context.Context, pod *corev1.Pod) bool {
+	if s.Monitors == nil {
+		return false
+	}
+	log := logf.FromContext(ctx).WithName("webhook.injector")
+
+	namespaceName := podNamespace(ctx, pod)
+	namespace := &corev1.Namespace{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: namespaceName}, namespace); err != nil {
+		log.Error(err, "failed to get namespace, skipping monitors", "namespace", namespaceName)
+		return false
+	}
+	if !monitors.Enabled(namespace) {
+		return false
+	}
+
+	// The monitors are looked up in the namespace of the pod.
+	pod = pod.DeepCopy()
+	pod.SetNamespace(namespaceName)
+	selected, err := s.Monitors.Selects(ctx, pod)
+	if err != nil {
+		log.Error(err, "failed to look up monitors, skipping monitors", "namespace", namespaceName)
+		return false
+	}
+
+	return selected
+}
+
+// podNamespace returns the namespace of the pod, which isn't always set on
+// pods that are being created.
+func podNamespace(ctx context.Context, pod *corev1.Pod) string {
+	if namespace := pod.GetNamespace(); namespace != "" {
+		return namespace
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		return req.Namespace
+	}
+
+	return ""
+}
+
+// checkClassNames returns an error if the pod requests a class by a name that
+// would reference the namespaced class of another namespace.
+func checkClassNames(pod *corev1.Pod) error {
+	for _, class := range metadata.SplitClasses(pod.GetAnnotations()[metadata.TelegrafConfigClassAnnotation]) {
+		if !metadata.ValidClassName(class) {
+			return fmt.Errorf("telegraf class: %s is not a valid class name, class names can't contain a slash", class)
+		}
+	}
+
+	return nil
+}
+
+// checkClassPolicy returns an error if the namespace of the pod isn't allowed
+// to use one of the requested classes and the class policy rejects such pods.
+func (s *SidecarInjector) checkClassPolicy(ctx context.Context, pod *corev1.Pod) error {
+	if s.ClassPolicy == nil || s.ClassPolicy.Action != classpolicy.ActionReject {
+		return nil
+	}
+	log := logf.FromContext(ctx).WithName("webhook.injector")
+
+	namespaceName := podNamespace(ctx, pod)
+
+	namespace := &corev1.Namespace{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: namespaceName}, namespace); err != nil {
+		// Consistent with the webhook failure policy, the pod is admitted and
+		// the class policy is enforced when its configuration is rendered.
+		log.Error(err, "failed to get namespace, skipping class policy check", "namespace", namespaceName)
+		return nil
+	}
+
+	class := s.DefaultClass
+	if selected, ok := s.ClassPolicy.SelectClass(pod, namespace); ok {
+		class = selected
+	}
+	if override, ok := pod.GetAnnotations()[metadata.TelegrafConfigClassAnnotation]; ok {
+		class = override
+	}
+
+	for _, class := range metadata.SplitClasses(class) {
+		if denied := s.deniedClasses(namespace, class); len(denied) > 0 {
+			return fmt.Errorf("telegraf class: %s is not allowed in namespace: %s",
+				strings.Join(denied, ","), namespaceName)
+		}
+	}
+
+	return nil
+}
+
+// deniedClasses returns the classes the namespace isn't allowed to use out of
+// class and the classes it inherits from. Pods are assigned to the canary
+// revision of a class by their UID, which a pod being created doesn't have yet,
+// so the classes the canary revision inherits from are checked as well.
+func (s *SidecarInjector) deniedClasses(namespace *corev1.Namespace, class string) []string {
+	if s.ClassDataHandler == nil {
+		return s.ClassPolicy.Denied(class, nil, namespace)
+	}
+
+	name := classdata.NamespacedClassName(namespace.GetName(), class)
+	data, ok := s.ClassDataHandler.GetDataForClass(name)
+	if !ok {
+		name = class
+		data, _ = s.ClassDataHandler.GetDataForClass(name)
+	}
+
+	denied := s.ClassPolicy.Denied(class, data, namespace)
+	if canaryData, ok := s.ClassDataHandler.GetDataForClass(name + classdata.CanarySuffix); ok {
+		for _, name := range s.ClassPolicy.Denied(class, canaryData, namespace) {
+			if !slices.Contains(denied, name) {
+				denied = append(denied, name)
+			}
+		}
+	}
+
+	return denied
 }
 
 func (s *SidecarInjector) hasTelegrafContainer(pod *corev1.Pod) bool {
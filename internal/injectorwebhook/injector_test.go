@@ -26,12 +26,15 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/jmickey/telegraf-sidecar-operator/internal/config"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/featuregate"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
 )
 
 const (
@@ -63,6 +66,75 @@ var _ = Describe("Sidecar injector webhook", func() {
 			})
 		})
 
+		Context("And the pod is selected by a monitor", func() {
+			It("Should inject the telegraf container for a PodMonitor in a namespace that opted in to monitors", func() {
+				ns := createTestNamespace("webhook-podmonitor", true)
+				monitor := newTestMonitor(monitors.PodMonitorGVK, ns, map[string]any{"app": "web"})
+				Expect(k8sClient.Create(testCtx, monitor)).To(Succeed())
+
+				pod := newTestPod("sidecar-podmonitor", nil)
+				pod.SetNamespace(ns)
+				pod.SetLabels(map[string]string{"app": "web"})
+				waitForMonitorSelection(pod)
+				Expect(k8sClient.Create(testCtx, pod)).To(Succeed())
+
+				Expect(pod.GetLabels()[metadata.SidecarInjectedLabel]).To(Equal("true"))
+				Expect(len(pod.Spec.Containers)).To(Equal(2))
+
+				cleanUpObject(pod, client.ObjectKeyFromObject(pod))
+				cleanUpObject(monitor, client.ObjectKeyFromObject(monitor))
+			})
+
+			It("Should inject the telegraf container for a ServiceMonitor in a namespace that opted in to monitors", func() {
+				ns := createTestNamespace("webhook-servicemonitor", true)
+				monitor := newTestMonitor(monitors.ServiceMonitorGVK, ns, map[string]any{"monitored": "true"})
+				Expect(k8sClient.Create(testCtx, monitor)).To(Succeed())
+				service := &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "api",
+						Namespace: ns,
+						Labels:    map[string]string{"monitored": "true"},
+					},
+					Spec: corev1.ServiceSpec{
+						Selector: map[string]string{"app": "api"},
+						Ports:    []corev1.ServicePort{{Name: "metrics", Port: 9090}},
+					},
+				}
+				Expect(k8sClient.Create(testCtx, service)).To(Succeed())
+
+				pod := newTestPod("sidecar-servicemonitor", nil)
+				pod.SetNamespace(ns)
+				pod.SetLabels(map[string]string{"app": "api"})
+				waitForMonitorSelection(pod)
+				Expect(k8sClient.Create(testCtx, pod)).To(Succeed())
+
+				Expect(pod.GetLabels()[metadata.SidecarInjectedLabel]).To(Equal("true"))
+				Expect(len(pod.Spec.Containers)).To(Equal(2))
+
+				cleanUpObject(pod, client.ObjectKeyFromObject(pod))
+				cleanUpObject(service, client.ObjectKeyFromObject(service))
+				cleanUpObject(monitor, client.ObjectKeyFromObject(monitor))
+			})
+
+			It("Should not inject the telegraf container in a namespace that didn't opt in to monitors", func() {
+				ns := createTestNamespace("webhook-unmonitored", false)
+				monitor := newTestMonitor(monitors.PodMonitorGVK, ns, map[string]any{"app": "web"})
+				Expect(k8sClient.Create(testCtx, monitor)).To(Succeed())
+
+				pod := newTestPod("sidecar-unmonitored", nil)
+				pod.SetNamespace(ns)
+				pod.SetLabels(map[string]string{"app": "web"})
+				waitForMonitorSelection(pod)
+				Expect(k8sClient.Create(testCtx, pod)).To(Succeed())
+
+				Expect(pod.GetLabels()).NotTo(HaveKey(metadata.SidecarInjectedLabel))
+				Expect(len(pod.Spec.Containers)).To(Equal(1))
+
+				cleanUpObject(pod, client.ObjectKeyFromObject(pod))
+				cleanUpObject(monitor, client.ObjectKeyFromObject(monitor))
+			})
+		})
+
 		Context("And there is a telegraf annotation", func() {
 			It("Should inject the telegraf container and config volume with default settings", func() {
 				podName := "sidecar-defaults"
@@ -132,6 +204,95 @@ var _ = Describe("Sidecar injector webhook", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It("Should add the config-ready readiness gate when the readiness gate feature is enabled", func() {
+				err := featuregate.Set("operator.readinessgate", true)
+				Expect(err).NotTo(HaveOccurred())
+				podName := "sidecar-readiness-gate"
+
+				pod := newTestPod(podName, map[string]string{
+					metadata.TelegrafConfigClassAnnotation: "default",
+				})
+				Expect(k8sClient.Create(testCtx, pod)).To(Succeed())
+
+				pod = &corev1.Pod{}
+				lookupKey := types.NamespacedName{Name: podName, Namespace: namespace}
+				Expect(k8sClient.Get(testCtx, lookupKey, pod)).To(Succeed())
+				Expect(pod.Spec.ReadinessGates).To(ConsistOf(corev1.PodReadinessGate{
+					ConditionType: metadata.ConfigReadyConditionType,
+				}))
+
+				cleanUpPod(pod.GetName())
+				err = featuregate.Set("operator.readinessgate", false)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("Should inject the telegraf container for prometheus.io/scrape when the prometheus annotations feature is enabled", func() {
+				err := featuregate.Set("telegraf.prometheusannotations", true)
+				Expect(err).NotTo(HaveOccurred())
+				podName := "sidecar-prometheus-scrape"
+
+				pod := newTestPod(podName, map[string]string{
+					metadata.PrometheusScrapeAnnotation: "true",
+					metadata.PrometheusPortAnnotation:   "8080",
+				})
+				Expect(k8sClient.Create(testCtx, pod)).To(Succeed())
+
+				pod = &corev1.Pod{}
+				lookupKey := types.NamespacedName{Name: podName, Namespace: namespace}
+				Expect(k8sClient.Get(testCtx, lookupKey, pod)).To(Succeed())
+				Expect(pod.GetLabels()[metadata.SidecarInjectedLabel]).To(Equal("true"))
+				Expect(len(pod.Spec.Containers)).To(Equal(2))
+
+				cleanUpPod(pod.GetName())
+				err = featuregate.Set("telegraf.prometheusannotations", false)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("Should reject the pod if the class is not allowed in its namespace", func() {
+				pod := newTestPod("sidecar-restricted-class", map[string]string{
+					metadata.TelegrafConfigClassAnnotation: "restrictedclass",
+				})
+				err := k8sClient.Create(testCtx, pod)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("telegraf class: restrictedclass is not allowed in namespace: " + namespace))
+			})
+
+			It("Should reject the pod if one of its classes is not allowed in its namespace", func() {
+				pod := newTestPod("sidecar-restricted-composed-class", map[string]string{
+					metadata.TelegrafConfigClassAnnotation: "default,restrictedclass",
+				})
+				err := k8sClient.Create(testCtx, pod)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("telegraf class: restrictedclass is not allowed in namespace: " + namespace))
+			})
+
+			It("Should reject the pod if its class extends a class not allowed in its namespace", func() {
+				pod := newTestPod("sidecar-inheriting-restricted-class", map[string]string{
+					metadata.TelegrafConfigClassAnnotation: "inheritingclass",
+				})
+				err := k8sClient.Create(testCtx, pod)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("telegraf class: restrictedclass is not allowed in namespace: " + namespace))
+			})
+
+			It("Should reject the pod if it requests the canary revision of a class not allowed in its namespace", func() {
+				pod := newTestPod("sidecar-restricted-canary-class", map[string]string{
+					metadata.TelegrafConfigClassAnnotation: "restrictedclass.canary",
+				})
+				err := k8sClient.Create(testCtx, pod)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("telegraf class: restrictedclass is not allowed in namespace: " + namespace))
+			})
+
+			It("Should reject the pod if it requests the namespaced class of another namespace", func() {
+				pod := newTestPod("sidecar-other-namespace-class", map[string]string{
+					metadata.TelegrafConfigClassAnnotation: "default,team-b/payments",
+				})
+				err := k8sClient.Create(testCtx, pod)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("telegraf class: team-b/payments is not a valid class name"))
+			})
+
 			It("Should truncate the secret name if the pod name is too long", func() {
 				podName := "long-pod-name-5yzuhd7fknyq24yfy9kquaj0aknw9vvu1fynqn08"
 
@@ -334,6 +495,60 @@ var _ = Describe("Sidecar injector webhook", func() {
 				cleanUpObject(&corev1.ConfigMap{}, types.NamespacedName{Name: configMapName, Namespace: namespace})
 			})
 
+			It("Should project the scrape auth annotations into the sidecar", func() {
+				podName := "sidecar-scrape-auth"
+
+				pod := newTestPod(podName, map[string]string{
+					metadata.TelegrafConfigTLSCAConfigMapAnnotation:            "kube-root-ca.crt/ca.crt",
+					metadata.TelegrafConfigTLSCertSecretAnnotation:             "client-tls",
+					metadata.TelegrafConfigBearerTokenServiceAccountAnnotation: "true",
+					metadata.TelegrafConfigBasicAuthSecretAnnotation:           "basic-auth",
+				})
+				Expect(k8sClient.Create(testCtx, pod)).To(Succeed())
+
+				pod = &corev1.Pod{}
+				lookupKey := types.NamespacedName{Name: podName, Namespace: namespace}
+				Expect(k8sClient.Get(testCtx, lookupKey, pod)).To(Succeed())
+
+				var volume *corev1.Volume
+				for i := range pod.Spec.Volumes {
+					if pod.Spec.Volumes[i].Name == metadata.ScrapeAuthVolumeName {
+						volume = &pod.Spec.Volumes[i]
+					}
+				}
+				Expect(volume).NotTo(BeNil())
+				Expect(volume.Projected.Sources).To(HaveLen(3))
+				Expect(volume.Projected.Sources[0].ConfigMap.Name).To(Equal("kube-root-ca.crt"))
+				Expect(volume.Projected.Sources[0].ConfigMap.Items).To(Equal([]corev1.KeyToPath{
+					{Key: "ca.crt", Path: metadata.ScrapeAuthCAFile},
+				}))
+				Expect(volume.Projected.Sources[1].Secret.Name).To(Equal("client-tls"))
+				Expect(volume.Projected.Sources[2].ServiceAccountToken.Path).To(Equal(metadata.ScrapeAuthTokenFile))
+
+				var found bool
+				for _, container := range pod.Spec.Containers {
+					if container.Name == containerName {
+						found = true
+						Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{
+							Name:      metadata.ScrapeAuthVolumeName,
+							MountPath: metadata.ScrapeAuthMountPath,
+							ReadOnly:  true,
+						}))
+						var envVars []string
+						for _, env := range container.Env {
+							if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+								Expect(env.ValueFrom.SecretKeyRef.Name).To(Equal("basic-auth"))
+								envVars = append(envVars, env.Name)
+							}
+						}
+						Expect(envVars).To(Equal([]string{metadata.ScrapeUsernameEnvVar, metadata.ScrapePasswordEnvVar}))
+					}
+				}
+				Expect(found).To(BeTrue())
+
+				cleanUpPod(pod.GetName())
+			})
+
 			It("Should add an environment variable literal value if `env-literal-` annotation exists", func() {
 				podName := "sidecar-env-literal"
 				envVarKey := "LITERAL_VAR"
@@ -967,6 +1182,47 @@ func newTestPod(name string, annotations map[string]string) *corev1.Pod {
 	}
 }
 
+// createTestNamespace creates a namespace, which optionally opted in to
+// monitors. Namespaces aren't removed by envtest, so they aren't cleaned up.
+func createTestNamespace(name string, monitored bool) string {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if monitored {
+		ns.SetLabels(map[string]string{metadata.TelegrafMonitorsNamespaceLabel: "true"})
+	}
+	Expect(k8sClient.Create(testCtx, ns)).To(Succeed())
+
+	return name
+}
+
+// newTestMonitor returns a PodMonitor or ServiceMonitor named metrics whose
+// selector matches labels.
+func newTestMonitor(gvk schema.GroupVersionKind, namespace string, labels map[string]any) *unstructured.Unstructured {
+	endpoints := "podMetricsEndpoints"
+	if gvk == monitors.ServiceMonitorGVK {
+		endpoints = "endpoints"
+	}
+
+	monitor := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"selector": map[string]any{"matchLabels": labels},
+			endpoints:  []any{map[string]any{"port": "metrics"}},
+		},
+	}}
+	monitor.SetGroupVersionKind(gvk)
+	monitor.SetNamespace(namespace)
+	monitor.SetName("metrics")
+
+	return monitor
+}
+
+// waitForMonitorSelection waits for the monitors selecting pod to be in the
+// cache of the webhook, so that it sees them when the pod is created.
+func waitForMonitorSelection(pod *corev1.Pod) {
+	Eventually(func() (bool, error) {
+		return monitorSource.Selects(testCtx, pod)
+	}, timeout, interval).Should(BeTrue())
+}
+
 func cleanUpPod(name string) {
 	podKey := types.NamespacedName{Name: name, Namespace: namespace}
 	Eventually(func() error {
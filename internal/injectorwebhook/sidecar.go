@@ -24,6 +24,7 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
@@ -45,8 +46,15 @@ type containerConfig struct {
 	envFrom         []corev1.EnvFromSource
 	volumeMounts    []corev1.VolumeMount
 	securityContext *corev1.SecurityContext
+	// scrapeAuthSources are the sources of the scrape auth volume, which is
+	// only added if there are any.
+	scrapeAuthSources []corev1.VolumeProjection
 }
 
+// scrapeTokenExpirationSeconds is the lifetime of the projected service
+// account token, which the kubelet rotates before it expires.
+const scrapeTokenExpirationSeconds = 3600
+
 func newContainerConfig(s *SidecarInjector) (*containerConfig, error) {
 	var err error
 	c := &containerConfig{
@@ -57,7 +65,7 @@ func newContainerConfig(s *SidecarInjector) (*containerConfig, error) {
 	// Setup default environment variables for the sidecar
 	c.env = []corev1.EnvVar{
 		{
-			Name: "PODNAME",
+			Name: metadata.PodNameEnvVar,
 			ValueFrom: &corev1.EnvVarSource{
 				FieldRef: &corev1.ObjectFieldSelector{
 					FieldPath: "metadata.name",
@@ -65,7 +73,7 @@ func newContainerConfig(s *SidecarInjector) (*containerConfig, error) {
 			},
 		},
 		{
-			Name: "NODENAME",
+			Name: metadata.NodeNameEnvVar,
 			ValueFrom: &corev1.EnvVarSource{
 				FieldRef: &corev1.ObjectFieldSelector{
 					FieldPath: "spec.nodeName",
@@ -73,7 +81,7 @@ func newContainerConfig(s *SidecarInjector) (*containerConfig, error) {
 			},
 		},
 		{
-			Name: "NAMESPACE",
+			Name: metadata.NamespaceEnvVar,
 			ValueFrom: &corev1.EnvVarSource{
 				FieldRef: &corev1.ObjectFieldSelector{
 					FieldPath: "metadata.namespace",
@@ -260,6 +268,105 @@ func (c *containerConfig) applyAnnotationOverrides(ctx context.Context, annotati
 			c.debug = true
 		}
 	}
+
+	c.applyScrapeAuthAnnotations(ctx, annotations)
+}
+
+// applyScrapeAuthAnnotations projects the CA, client certificate and bearer
+// token referenced by the scrape auth annotations into the scrape auth volume,
+// and exposes the basic auth credentials as environment variables.
+func (c *containerConfig) applyScrapeAuthAnnotations(ctx context.Context, annotations map[string]string) {
+	log := logf.FromContext(ctx).WithName("webhook.sidecar")
+
+	if value, ok := annotations[metadata.TelegrafConfigTLSCASecretAnnotation]; ok {
+		if name, key, ok := metadata.ParseKeyRef(value); ok {
+			c.scrapeAuthSources = append(c.scrapeAuthSources, corev1.VolumeProjection{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Items:                []corev1.KeyToPath{{Key: key, Path: metadata.ScrapeAuthCAFile}},
+				},
+			})
+		} else {
+			log.Info("failed to parse tls ca secret reference", "invalidValue", value)
+		}
+	} else if value, ok := annotations[metadata.TelegrafConfigTLSCAConfigMapAnnotation]; ok {
+		if name, key, ok := metadata.ParseKeyRef(value); ok {
+			c.scrapeAuthSources = append(c.scrapeAuthSources, corev1.VolumeProjection{
+				ConfigMap: &corev1.ConfigMapProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Items:                []corev1.KeyToPath{{Key: key, Path: metadata.ScrapeAuthCAFile}},
+				},
+			})
+		} else {
+			log.Info("failed to parse tls ca configmap reference", "invalidValue", value)
+		}
+	}
+
+	if name, ok := annotations[metadata.TelegrafConfigTLSCertSecretAnnotation]; ok && name != "" {
+		c.scrapeAuthSources = append(c.scrapeAuthSources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Items: []corev1.KeyToPath{
+					{Key: corev1.TLSCertKey, Path: metadata.ScrapeAuthCertFile},
+					{Key: corev1.TLSPrivateKeyKey, Path: metadata.ScrapeAuthKeyFile},
+				},
+			},
+		})
+	}
+
+	// Both tokens would be projected to the same file, which the API server
+	// rejects.
+	if value, ok := annotations[metadata.TelegrafConfigBearerTokenSecretAnnotation]; ok {
+		if name, key, ok := metadata.ParseKeyRef(value); ok {
+			c.scrapeAuthSources = append(c.scrapeAuthSources, corev1.VolumeProjection{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Items:                []corev1.KeyToPath{{Key: key, Path: metadata.ScrapeAuthTokenFile}},
+				},
+			})
+		} else {
+			log.Info("failed to parse bearer token secret reference", "invalidValue", value)
+		}
+	} else if annotations[metadata.TelegrafConfigBearerTokenServiceAccountAnnotation] == "true" {
+		c.scrapeAuthSources = append(c.scrapeAuthSources, corev1.VolumeProjection{
+			ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+				Path:              metadata.ScrapeAuthTokenFile,
+				ExpirationSeconds: ptr.To[int64](scrapeTokenExpirationSeconds),
+			},
+		})
+	}
+
+	if name, ok := annotations[metadata.TelegrafConfigBasicAuthSecretAnnotation]; ok && name != "" {
+		for _, ref := range []struct{ envVar, key string }{
+			{metadata.ScrapeUsernameEnvVar, corev1.BasicAuthUsernameKey},
+			{metadata.ScrapePasswordEnvVar, corev1.BasicAuthPasswordKey},
+		} {
+			c.env = append(c.env, corev1.EnvVar{
+				Name: ref.envVar,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  ref.key,
+					},
+				},
+			})
+		}
+	}
+}
+
+// buildScrapeAuthVolume returns the scrape auth volume, or nil if the pod
+// doesn't use any of the scrape auth annotations projected into it.
+func (c *containerConfig) buildScrapeAuthVolume() *corev1.Volume {
+	if len(c.scrapeAuthSources) == 0 {
+		return nil
+	}
+
+	return &corev1.Volume{
+		Name: metadata.ScrapeAuthVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: c.scrapeAuthSources},
+		},
+	}
 }
 
 func (c *containerConfig) buildContainerSpec() corev1.Container {
@@ -306,6 +413,14 @@ func (c *containerConfig) buildContainerSpec() corev1.Container {
 		}),
 	}
 
+	if len(c.scrapeAuthSources) > 0 {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      metadata.ScrapeAuthVolumeName,
+			MountPath: metadata.ScrapeAuthMountPath,
+			ReadOnly:  true,
+		})
+	}
+
 	return container
 }
 
@@ -42,7 +42,10 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classdata"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/classpolicy"
 	"github.com/jmickey/telegraf-sidecar-operator/internal/config"
+	"github.com/jmickey/telegraf-sidecar-operator/internal/monitors"
 )
 
 var cfg *rest.Config
@@ -51,6 +54,7 @@ var testEnv *envtest.Environment
 var ctx context.Context
 var cancel context.CancelFunc
 var injector *SidecarInjector
+var monitorSource *monitors.Source
 
 var (
 	defaultTelegrafImage  = "telegraf:1.30-alpine"
@@ -73,7 +77,10 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "config", "crd", "bases"),
+			filepath.Join("..", "..", "config", "testdata", "crds"),
+		},
 		ErrorIfCRDPathMissing: false,
 
 		// The BinaryAssetsDirectory is only required if you want to run the tests directly
@@ -121,6 +128,16 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
+	// restrictedclass is unavailable to all namespaces.
+	classPolicy, err := classpolicy.Parse([]byte("classes:\n  - name: restrictedclass\n"))
+	Expect(err).NotTo(HaveOccurred())
+
+	classDataHandler, err := classdata.NewDirectoryHandler("../../config/testdata/telegrafClasses")
+	Expect(err).NotTo(HaveOccurred())
+
+	monitorSource, err = monitors.NewSource(ctx, mgr)
+	Expect(err).NotTo(HaveOccurred())
+
 	injector = &SidecarInjector{
 		SecretNamePrefix:                 "telegraf",
 		TelegrafImage:                    defaultTelegrafImage,
@@ -135,6 +152,11 @@ var _ = BeforeSuite(func() {
 		SecurityAllowPrivilegeEscalation: &config.OptionalBool{},
 		SecurityCapabilitiesAdd:          "",
 		SecurityCapabilitiesDrop:         "",
+		ClassPolicy:                      classPolicy,
+		Client:                           mgr.GetAPIReader(),
+		DefaultClass:                     "default",
+		ClassDataHandler:                 classDataHandler,
+		Monitors:                         monitorSource,
 	}
 
 	err = injector.SetupWithManager(mgr)
@@ -124,12 +124,71 @@ const (
 	// "metric1, metric2"
 	TelegrafConfigMetricsNamepass = Prefix + "/namepass"
 
+	// TelegrafConfigMetricsNamedrop can be used to configure the namedrop
+	// setting for the Prometheus input plugin. Metrics whose measurement name
+	// matches a pattern in this list are dropped. Same format as
+	// TelegrafConfigMetricsNamepass.
+	TelegrafConfigMetricsNamedrop = Prefix + "/namedrop"
+
+	// TelegrafConfigMetricsFieldpass can be used to configure the fieldpass
+	// setting for the Prometheus input plugin. Only fields whose key matches
+	// a pattern in this list are emitted. Same format as
+	// TelegrafConfigMetricsNamepass.
+	TelegrafConfigMetricsFieldpass = Prefix + "/fieldpass"
+
+	// TelegrafConfigMetricsFielddrop can be used to configure the fielddrop
+	// setting for the Prometheus input plugin. Fields whose key matches a
+	// pattern in this list are dropped. Same format as
+	// TelegrafConfigMetricsNamepass.
+	TelegrafConfigMetricsFielddrop = Prefix + "/fielddrop"
+
+	// TelegrafConfigMetricsTaginclude can be used to configure the taginclude
+	// setting for the Prometheus input plugin. Only tags whose key matches a
+	// pattern in this list are kept. Same format as
+	// TelegrafConfigMetricsNamepass.
+	TelegrafConfigMetricsTaginclude = Prefix + "/taginclude"
+
+	// TelegrafConfigMetricsTagexclude can be used to configure the tagexclude
+	// setting for the Prometheus input plugin. Tags whose key matches a
+	// pattern in this list are removed. Same format as
+	// TelegrafConfigMetricsNamepass.
+	TelegrafConfigMetricsTagexclude = Prefix + "/tagexclude"
+
+	// TelegrafConfigMetricsTagpass can be used to configure the tagpass
+	// setting for the Prometheus input plugin. Only metrics with a tag whose
+	// value matches one of the patterns of the tag are emitted. Must be a
+	// JSON or YAML map of tag keys to lists of glob patterns, e.g.
+	// `{"method": ["GET", "POST"]}`
+	TelegrafConfigMetricsTagpass = Prefix + "/tagpass"
+
+	// TelegrafConfigMetricsTagdrop can be used to configure the tagdrop
+	// setting for the Prometheus input plugin. Metrics with a tag whose value
+	// matches one of the patterns of the tag are dropped. Same format as
+	// TelegrafConfigMetricsTagpass.
+	TelegrafConfigMetricsTagdrop = Prefix + "/tagdrop"
+
 	// TelegrafConfigIntervalAnnotation can be used to configure
 	// the scraping interval. Value must be a value to Go style
 	// duration, e.g. 10s, 30s, 1m.
 	// Default: 10s
 	TelegrafConfigIntervalAnnotation = Prefix + "/interval"
 
+	// TelegrafConfigEndpointsAnnotation can be used to configure endpoints
+	// scraped by the Prometheus input plugin with their own settings. Must be
+	// a JSON or YAML list of endpoints, unset settings default to the values
+	// of the other telegraf annotations.
+	// e.g.
+	// telegraf.influxdata.com/endpoints: |+
+	//   - port: 8080
+	//   - port: 9090
+	//     path: /actuator/prometheus
+	//     interval: 1m
+	//     timeout: 5s
+	//     headers:
+	//       Accept: text/plain
+	//     namedrop: ["jvm_*"]
+	TelegrafConfigEndpointsAnnotation = Prefix + "/endpoints"
+
 	// TelegrafConfigRawInputAnnotation can be used to configure
 	// a raw telegraf input TOML block. Can be provided as a multiline
 	// block of raw TOML configuration.
@@ -163,6 +222,12 @@ const (
 	//       replacement = "${1}"
 	TelegrafConfigRawProcessorsAnnotation = Prefix + "/processors"
 
+	// TelegrafConfigReplacePluginsAnnotation can be used to replace plugins
+	// defined by the class with the plugins configured by the pod annotations,
+	// which are otherwise appended. Must be a comma-separated list of plugin
+	// types or plugins, e.g. "processors, inputs.prometheus".
+	TelegrafConfigReplacePluginsAnnotation = Prefix + "/replace-plugins"
+
 	// TelegrafConfigEnableInternalAnnotation enables the "internal"
 	// telegraf plugin. Any non-empty string value is accepted.
 	TelegrafConfigEnableInternalAnnotation = Prefix + "/internal"
@@ -172,6 +237,68 @@ const (
 	// debug flag which produces verbose output for troubleshooting.
 	TelegrafConfigDebugLogAnnotation = Prefix + "/debug"
 
+	/*
+	 * Scrape Authentication Annotations
+	 */
+
+	// TelegrafConfigTLSCASecretAnnotation can be used to verify the
+	// certificates of the scraped endpoints with a CA from a Secret key.
+	// Must be in the format: `<secretName>/<key>`
+	TelegrafConfigTLSCASecretAnnotation = Prefix + "/tls-ca-secret"
+
+	// TelegrafConfigTLSCAConfigMapAnnotation can be used to verify the
+	// certificates of the scraped endpoints with a CA from a ConfigMap key.
+	// Ignored if telegraf.influxdata.com/tls-ca-secret is set.
+	// Must be in the format: `<configMapName>/<key>`
+	TelegrafConfigTLSCAConfigMapAnnotation = Prefix + "/tls-ca-configmap"
+
+	// TelegrafConfigTLSCertSecretAnnotation can be used to authenticate to the
+	// scraped endpoints with a client certificate. Must be the name of a
+	// Secret with the tls.crt and tls.key keys.
+	TelegrafConfigTLSCertSecretAnnotation = Prefix + "/tls-cert-secret"
+
+	// TelegrafConfigTLSServerNameAnnotation can be used to set the server name
+	// the certificates of the scraped endpoints are verified for, as they are
+	// scraped through localhost.
+	TelegrafConfigTLSServerNameAnnotation = Prefix + "/tls-server-name"
+
+	// TelegrafConfigBearerTokenSecretAnnotation can be used to authenticate to
+	// the scraped endpoints with a bearer token from a Secret key.
+	// Must be in the format: `<secretName>/<key>`
+	TelegrafConfigBearerTokenSecretAnnotation = Prefix + "/bearer-token-secret"
+
+	// TelegrafConfigBearerTokenServiceAccountAnnotation can be used to
+	// authenticate to the scraped endpoints with a projected token of the
+	// service account of the pod, e.g. for kube-rbac-proxy. Set to "true" to
+	// enable. Ignored if telegraf.influxdata.com/bearer-token-secret is set.
+	TelegrafConfigBearerTokenServiceAccountAnnotation = Prefix + "/bearer-token-service-account"
+
+	// TelegrafConfigBasicAuthSecretAnnotation can be used to authenticate to
+	// the scraped endpoints with basic auth. Must be the name of a Secret with
+	// the username and password keys.
+	TelegrafConfigBasicAuthSecretAnnotation = Prefix + "/basic-auth-secret"
+
+	/*
+	 * Prometheus Compatibility Annotations
+	 */
+
+	// PrometheusScrapeAnnotation enables scraping the pod with the Prometheus
+	// input plugin when set to "true". Requires the
+	// telegraf.prometheusannotations feature gate to be enabled.
+	PrometheusScrapeAnnotation = PrometheusPrefix + "/scrape"
+
+	// PrometheusPortAnnotation is the port to scrape, used unless
+	// telegraf.influxdata.com/ports is set.
+	PrometheusPortAnnotation = PrometheusPrefix + "/port"
+
+	// PrometheusPathAnnotation is the HTTP path to scrape, used unless
+	// telegraf.influxdata.com/path is set.
+	PrometheusPathAnnotation = PrometheusPrefix + "/path"
+
+	// PrometheusSchemeAnnotation is the request scheme to scrape with, used
+	// unless telegraf.influxdata.com/scheme is set.
+	PrometheusSchemeAnnotation = PrometheusPrefix + "/scheme"
+
 	/*
 	 * Telagraf Configuration Prefix Annotations
 	 */
@@ -179,4 +306,45 @@ const (
 	// TelegrafConfigGlobalTagLiteralPrefixAnnotation can be used to a literal value
 	// to the global_tags in the telegraf configuration.
 	TelegrafConfigGlobalTagLiteralPrefixAnnotation = Prefix + "/global-tag-literal-"
+
+	/*
+	 * Operator Managed Annotations
+	 */
+
+	// SecretAppliedAnnotationsAnnotation is set by the operator on the telegraf
+	// config secret and records the pod annotations the configuration was last
+	// rendered from, as a JSON object.
+	SecretAppliedAnnotationsAnnotation = Prefix + "/applied-annotations"
+
+	// SecretAnnotationsHashAnnotation is set by the operator on the telegraf
+	// config secret and records the hash of the applied pod annotations.
+	SecretAnnotationsHashAnnotation = Prefix + "/annotations-hash"
+
+	// SecretOperatorVersionAnnotation is set by the operator on the telegraf
+	// config secret and records the version of the operator that rendered it.
+	SecretOperatorVersionAnnotation = Prefix + "/operator-version"
+
+	// SecretOperatorGitCommitAnnotation is set by the operator on the telegraf
+	// config secret and records the git commit of the operator that rendered it.
+	SecretOperatorGitCommitAnnotation = Prefix + "/operator-git-commit"
+
+	// SecretComposedClassesAnnotation is set by the operator on the telegraf
+	// config secret of a pod using more than one class, and records the
+	// classes the configuration was rendered from, in order.
+	SecretComposedClassesAnnotation = Prefix + "/composed-classes"
+
+	// SecretNamespacedClassesAnnotation is set by the operator on the telegraf
+	// config secret of a pod using a TelegrafNamespaceClass, and records the
+	// classes that were rendered from a class in the namespace of the pod
+	// rather than from a cluster class of the same name.
+	SecretNamespacedClassesAnnotation = Prefix + "/namespaced-classes"
+
+	// ConfigHashAnnotation is set by the operator on both the telegraf config
+	// secret and the pod, and records the hash of the rendered configuration.
+	ConfigHashAnnotation = Prefix + "/config-hash"
+
+	// ClassFallbackAnnotation is set by the operator on a pod requesting a
+	// class that doesn't exist, and records how its configuration was rendered
+	// according to the missing class policy: "default", "minimal" or "fail".
+	ClassFallbackAnnotation = Prefix + "/class-fallback"
 )
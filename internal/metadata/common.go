@@ -18,4 +18,35 @@ package metadata
 
 const (
 	Prefix = "telegraf.influxdata.com"
+
+	// PrometheusPrefix is the prefix of the prometheus.io scrape annotations.
+	PrometheusPrefix = "prometheus.io"
+
+	// ConfigReadyConditionType is the pod readiness gate and condition type
+	// reporting whether the telegraf configuration of the pod has been rendered.
+	ConfigReadyConditionType = Prefix + "/config-ready"
+
+	// ScrapeAuthVolumeName is the name of the projected volume holding the
+	// CA, client certificate and bearer token used to scrape the pod, which
+	// is mounted into the sidecar at ScrapeAuthMountPath.
+	ScrapeAuthVolumeName = "telegraf-scrape-auth"
+	ScrapeAuthMountPath  = "/etc/telegraf-scrape-auth"
+
+	// The files of the scrape auth volume.
+	ScrapeAuthCAFile    = "ca.crt"
+	ScrapeAuthCertFile  = "tls.crt"
+	ScrapeAuthKeyFile   = "tls.key"
+	ScrapeAuthTokenFile = "token"
+
+	// PodNameEnvVar, NodeNameEnvVar and NamespaceEnvVar are the environment
+	// variables the webhook adds to every sidecar.
+	PodNameEnvVar   = "PODNAME"
+	NodeNameEnvVar  = "NODENAME"
+	NamespaceEnvVar = "NAMESPACE"
+
+	// ScrapeUsernameEnvVar and ScrapePasswordEnvVar are the environment
+	// variables of the sidecar holding the basic auth credentials used to
+	// scrape the pod.
+	ScrapeUsernameEnvVar = "TELEGRAF_SCRAPE_USERNAME"
+	ScrapePasswordEnvVar = "TELEGRAF_SCRAPE_PASSWORD"
 )
@@ -22,6 +22,23 @@ const (
 	SecretManagedByLabelKey      = "app.kubernetes.io/managed-by"
 	SidecarInjectedLabel         = Prefix + "/injected"
 	SidecarSecretNameLabel       = Prefix + "/secret-name"
+	TelegrafClassesLabel         = Prefix + "/classes"
 	TelegrafSecretClassNameLabel = Prefix + "/class"
+	TelegrafSecretClassHashLabel = Prefix + "/class-hash"
 	TelegrafSecretPodLabel       = Prefix + "/pod"
+
+	// TelegrafSecretClassRevisionLabel is set to ClassRevisionCanary on the
+	// telegraf config secrets rendered from the canary revision of a class.
+	TelegrafSecretClassRevisionLabel = Prefix + "/class-revision"
+	ClassRevisionCanary              = "canary"
+
+	// TelegrafSecretOutdatedLabel is set to "true" on the telegraf config
+	// secrets that weren't rendered from the current revision of their
+	// classes, because re-rendering failed or a class no longer exists.
+	TelegrafSecretOutdatedLabel = Prefix + "/outdated"
+
+	// TelegrafMonitorsNamespaceLabel opts the pods of a namespace in to having
+	// the prometheus-operator PodMonitors and ServiceMonitors selecting them
+	// translated into telegraf inputs, when set to "true" on the namespace.
+	TelegrafMonitorsNamespaceLabel = Prefix + "/monitors"
 )
@@ -11,3 +11,37 @@ func GetAnnotationsWithPrefix(annotations map[string]string, prefix string) map[
 	}
 	return values
 }
+
+// SplitClasses returns the classes of a comma-separated class annotation, in
+// order, ignoring empty entries.
+func SplitClasses(value string) []string {
+	var classes []string
+	for _, class := range strings.Split(value, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// ValidClassName returns whether a pod can request class. The namespaced
+// classes are served under their namespace and name separated by a slash, a
+// class name containing one would reference the classes of another namespace.
+func ValidClassName(class string) bool {
+	return !strings.Contains(class, "/")
+}
+
+// PrometheusScrapeEnabled returns whether the prometheus.io/scrape annotation
+// of a pod is set to "true".
+func PrometheusScrapeEnabled(annotations map[string]string) bool {
+	return strings.EqualFold(strings.TrimSpace(annotations[PrometheusScrapeAnnotation]), "true")
+}
+
+// ParseKeyRef parses a reference to the key of a Secret or ConfigMap in the
+// format `<name>/<key>`. Unlike the `<name>.<key>` format of the env
+// annotations, it can reference objects with dots in their name, such as the
+// kube-root-ca.crt ConfigMap.
+func ParseKeyRef(value string) (name, key string, ok bool) {
+	name, key, ok = strings.Cut(strings.TrimSpace(value), "/")
+	return name, key, ok && name != "" && key != ""
+}
@@ -0,0 +1,624 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitors
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+)
+
+var (
+	// PodMonitorGVK is the prometheus-operator PodMonitor, whose endpoints are
+	// ports of the selected pods.
+	PodMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"}
+	// ServiceMonitorGVK is the prometheus-operator ServiceMonitor, whose
+	// endpoints are ports of the selected Services.
+	ServiceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+)
+
+// Monitor is a PodMonitor or ServiceMonitor. The monitors are read as
+// unstructured objects, so that the prometheus-operator CRDs don't need to be
+// installed.
+type Monitor struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Endpoints []Endpoint
+
+	selector   labels.Selector
+	namespaces NamespaceSelector
+}
+
+// NamespaceSelector selects the namespaces of the pods or Services a monitor
+// applies to. A monitor without a NamespaceSelector applies to its own namespace.
+type NamespaceSelector struct {
+	Any        bool     `json:"any,omitempty"`
+	MatchNames []string `json:"matchNames,omitempty"`
+}
+
+// Endpoint is a scrape endpoint of a monitor. Port is the name of a container
+// port for a PodMonitor, and the name of a Service port for a ServiceMonitor.
+type Endpoint struct {
+	Port              string              `json:"port,omitempty"`
+	PortNumber        int32               `json:"portNumber,omitempty"`
+	TargetPort        *intstr.IntOrString `json:"targetPort,omitempty"`
+	Path              string              `json:"path,omitempty"`
+	Scheme            string              `json:"scheme,omitempty"`
+	Interval          string              `json:"interval,omitempty"`
+	TLSConfig         *TLSConfig          `json:"tlsConfig,omitempty"`
+	MetricRelabelings []RelabelConfig     `json:"metricRelabelings,omitempty"`
+}
+
+// TLSConfig is the TLS configuration of an endpoint. Only InsecureSkipVerify
+// and ServerName are translated, the certificates are only available to
+// Prometheus.
+type TLSConfig struct {
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	CA                 any    `json:"ca,omitempty"`
+	Cert               any    `json:"cert,omitempty"`
+	KeySecret          any    `json:"keySecret,omitempty"`
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+}
+
+// RelabelConfig is a metric relabeling of an endpoint.
+type RelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Action       string   `json:"action,omitempty"`
+}
+
+type monitorSpec struct {
+	Selector            metav1.LabelSelector `json:"selector"`
+	NamespaceSelector   NamespaceSelector    `json:"namespaceSelector,omitempty"`
+	PodMetricsEndpoints []Endpoint           `json:"podMetricsEndpoints,omitempty"`
+	Endpoints           []Endpoint           `json:"endpoints,omitempty"`
+}
+
+// Parse returns the Monitor defined by a PodMonitor or ServiceMonitor object.
+func Parse(obj *unstructured.Unstructured) (*Monitor, error) {
+	kind := obj.GetKind()
+	if kind != PodMonitorGVK.Kind && kind != ServiceMonitorGVK.Kind {
+		return nil, fmt.Errorf("unsupported monitor kind: %s", kind)
+	}
+
+	raw, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s/%s, error: %w", kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	var spec monitorSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s/%s, error: %w", kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of %s: %s/%s, error: %w", kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	m := &Monitor{
+		Kind:       kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Endpoints:  spec.PodMetricsEndpoints,
+		selector:   selector,
+		namespaces: spec.NamespaceSelector,
+	}
+	if kind == ServiceMonitorGVK.Kind {
+		m.Endpoints = spec.Endpoints
+	}
+
+	return m, nil
+}
+
+// String returns the kind, namespace and name of the monitor.
+func (m *Monitor) String() string {
+	return fmt.Sprintf("%s: %s/%s", m.Kind, m.Namespace, m.Name)
+}
+
+// AppliesToNamespace returns whether the monitor applies to the pods or
+// Services in namespace.
+func (m *Monitor) AppliesToNamespace(namespace string) bool {
+	switch {
+	case m.namespaces.Any:
+		return true
+	case len(m.namespaces.MatchNames) > 0:
+		return slices.Contains(m.namespaces.MatchNames, namespace)
+	default:
+		return namespace == m.Namespace
+	}
+}
+
+// Enabled returns whether monitors are translated for the pods in namespace,
+// which namespaces opt in to with the monitors label.
+func Enabled(namespace *corev1.Namespace) bool {
+	return namespace != nil && namespace.GetLabels()[metadata.TelegrafMonitorsNamespaceLabel] == "true"
+}
+
+// ServiceSelectorIndex indexes Services by every key=value pair of their
+// selector, so that the Services that may select a pod are looked up by the
+// labels of the pod, rather than listing every Service of its namespace.
+const ServiceSelectorIndex = "spec.selector"
+
+func indexServiceSelector(obj client.Object) []string {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil
+	}
+
+	values := make([]string, 0, len(svc.Spec.Selector))
+	for key, value := range svc.Spec.Selector {
+		values = append(values, key+"="+value)
+	}
+
+	return values
+}
+
+// Source looks up the monitors selecting a pod.
+type Source struct {
+	// monitors reads the monitor objects, pods the pods they select, and
+	// services the Services selected by ServiceMonitors.
+	monitors client.Reader
+	pods     client.Reader
+	services client.Reader
+	kinds    []schema.GroupVersionKind
+}
+
+// NewSource returns a Source for the monitor kinds whose CRDs are installed in
+// the cluster of the manager. The monitors and the Services selected by
+// ServiceMonitors are read through the cache of the manager, as they are looked
+// up on every admission of a pod.
+func NewSource(ctx context.Context, mgr manager.Manager) (*Source, error) {
+	var kinds []schema.GroupVersionKind
+	for _, gvk := range []schema.GroupVersionKind{PodMonitorGVK, ServiceMonitorGVK} {
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to look up kind: %s, error: %w", gvk.Kind, err)
+		}
+		kinds = append(kinds, gvk)
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &corev1.Service{}, ServiceSelectorIndex,
+		indexServiceSelector); err != nil {
+		return nil, fmt.Errorf("failed to index services by selector, error: %w", err)
+	}
+
+	return newSource(mgr.GetCache(), mgr.GetClient(), mgr.GetClient(), kinds), nil
+}
+
+func newSource(monitors, pods, services client.Reader, kinds []schema.GroupVersionKind) *Source {
+	return &Source{monitors: monitors, pods: pods, services: services, kinds: kinds}
+}
+
+// Kinds returns the monitor kinds that are installed in the cluster.
+func (s *Source) Kinds() []schema.GroupVersionKind {
+	return s.kinds
+}
+
+// list returns the monitors of all installed kinds that apply to namespace.
+// Invalid monitors are skipped, and returned as warnings.
+func (s *Source) list(ctx context.Context, namespace string) ([]*Monitor, []string, error) {
+	var monitors []*Monitor
+	var warnings []string
+	for _, gvk := range s.kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := s.monitors.List(ctx, list); err != nil {
+			return nil, nil, fmt.Errorf("failed to list %s objects, error: %w", gvk.Kind, err)
+		}
+
+		for i := range list.Items {
+			m, err := Parse(&list.Items[i])
+			if err != nil {
+				warnings = append(warnings, err.Error())
+				continue
+			}
+			if m.AppliesToNamespace(namespace) {
+				monitors = append(monitors, m)
+			}
+		}
+	}
+
+	return monitors, warnings, nil
+}
+
+// Targets returns the endpoints of the monitors selecting pod, resolved to the
+// ports of the pod. The warnings describe the monitor settings that can't be
+// translated into telegraf settings, and the invalid monitors.
+func (s *Source) Targets(ctx context.Context, pod *corev1.Pod) ([]Target, []string, error) {
+	monitors, warnings, err := s.list(ctx, pod.GetNamespace())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var targets []Target
+	for _, m := range monitors {
+		if m.Kind == PodMonitorGVK.Kind {
+			if !m.selector.Matches(labels.Set(pod.GetLabels())) {
+				continue
+			}
+			t, w := m.podTargets(pod)
+			targets = append(targets, t...)
+			warnings = append(warnings, w...)
+			continue
+		}
+
+		services, err := s.selectedServices(ctx, m, pod)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range services {
+			t, w := m.serviceTargets(&services[i], pod)
+			targets = append(targets, t...)
+			warnings = append(warnings, w...)
+		}
+	}
+
+	return targets, warnings, nil
+}
+
+// Selects returns whether any monitor selects pod, without resolving the
+// endpoints of the monitors.
+func (s *Source) Selects(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	monitors, _, err := s.list(ctx, pod.GetNamespace())
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range monitors {
+		if m.Kind == PodMonitorGVK.Kind {
+			if m.selector.Matches(labels.Set(pod.GetLabels())) {
+				return true, nil
+			}
+			continue
+		}
+
+		services, err := s.selectedServices(ctx, m, pod)
+		if err != nil {
+			return false, err
+		}
+		if len(services) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// selectedServices returns the Services in the namespace of pod that are
+// selected by the ServiceMonitor m, and select pod.
+func (s *Source) selectedServices(ctx context.Context, m *Monitor, pod *corev1.Pod) ([]corev1.Service, error) {
+	// A Service selecting the pod has at least one of the labels of the pod in
+	// its selector, a Service without a selector doesn't select any pods.
+	var services []corev1.Service
+	for key, value := range pod.GetLabels() {
+		list := &corev1.ServiceList{}
+		if err := s.services.List(ctx, list, client.InNamespace(pod.GetNamespace()),
+			client.MatchingFields{ServiceSelectorIndex: key + "=" + value},
+			client.MatchingLabelsSelector{Selector: m.selector}); err != nil {
+			return nil, fmt.Errorf("failed to list services for %s, error: %w", m, err)
+		}
+
+		for _, svc := range list.Items {
+			if slices.ContainsFunc(services, func(selected corev1.Service) bool {
+				return selected.GetName() == svc.GetName()
+			}) {
+				continue
+			}
+			if labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.GetLabels())) {
+				services = append(services, svc)
+			}
+		}
+	}
+	// The order of the targets follows the Services.
+	slices.SortFunc(services, func(a, b corev1.Service) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+
+	return services, nil
+}
+
+// Pods returns the pods with the telegraf sidecar injected that are selected
+// by the monitor obj.
+func (s *Source) Pods(ctx context.Context, obj *unstructured.Unstructured) ([]types.NamespacedName, error) {
+	m, err := Parse(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// The namespaces are the ones of the pods, the pods of the namespaces that
+	// aren't selected are filtered below.
+	var opts []client.ListOption
+	if !m.namespaces.Any && len(m.namespaces.MatchNames) == 0 {
+		opts = append(opts, client.InNamespace(m.Namespace))
+	}
+
+	var selectors []labels.Selector
+	if m.Kind == PodMonitorGVK.Kind {
+		selectors = append(selectors, m.selector)
+	} else {
+		services := &corev1.ServiceList{}
+		if err := s.services.List(ctx, services, append(opts, client.MatchingLabelsSelector{Selector: m.selector})...); err != nil {
+			return nil, fmt.Errorf("failed to list services for %s, error: %w", m, err)
+		}
+		for _, svc := range services.Items {
+			if len(svc.Spec.Selector) > 0 && m.AppliesToNamespace(svc.GetNamespace()) {
+				selectors = append(selectors, labels.SelectorFromSet(svc.Spec.Selector))
+			}
+		}
+	}
+
+	var pods []types.NamespacedName
+	for _, selector := range selectors {
+		list := &corev1.PodList{}
+		if err := s.pods.List(ctx, list, append(opts, client.MatchingLabelsSelector{Selector: selector},
+			client.HasLabels{metadata.SidecarInjectedLabel})...); err != nil {
+			return nil, fmt.Errorf("failed to list pods for %s, error: %w", m, err)
+		}
+		for _, pod := range list.Items {
+			key := client.ObjectKeyFromObject(&pod)
+			if m.AppliesToNamespace(pod.GetNamespace()) && !slices.Contains(pods, key) {
+				pods = append(pods, key)
+			}
+		}
+	}
+
+	return pods, nil
+}
+
+// containerPort returns the number of the container port of pod with the given name.
+func containerPort(pod *corev1.Pod, name string) (int32, bool) {
+	for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, container := range containers {
+			for _, port := range container.Ports {
+				if port.Name == name {
+					return port.ContainerPort, true
+				}
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// resolvePort returns the number of a port of pod given by number or name.
+func resolvePort(pod *corev1.Pod, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, port.IntVal > 0
+	}
+
+	return containerPort(pod, port.StrVal)
+}
+
+func (m *Monitor) podTargets(pod *corev1.Pod) ([]Target, []string) {
+	var targets []Target
+	var warnings []string
+	for i, endpoint := range m.Endpoints {
+		var port int32
+		var ok bool
+		switch {
+		case endpoint.PortNumber > 0:
+			port, ok = endpoint.PortNumber, true
+		case endpoint.Port != "":
+			port, ok = containerPort(pod, endpoint.Port)
+		case endpoint.TargetPort != nil:
+			port, ok = resolvePort(pod, *endpoint.TargetPort)
+		}
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d doesn't match a port of the pod", m, i))
+			continue
+		}
+
+		target, w := m.target(i, endpoint, port)
+		targets = append(targets, target)
+		warnings = append(warnings, w...)
+	}
+
+	return targets, warnings
+}
+
+func (m *Monitor) serviceTargets(svc *corev1.Service, pod *corev1.Pod) ([]Target, []string) {
+	var targets []Target
+	var warnings []string
+	for i, endpoint := range m.Endpoints {
+		var port int32
+		var ok bool
+		switch {
+		case endpoint.Port != "":
+			for _, svcPort := range svc.Spec.Ports {
+				if svcPort.Name == endpoint.Port {
+					targetPort := svcPort.TargetPort
+					// The target port of a Service port defaults to its port.
+					if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+						targetPort = intstr.FromInt32(svcPort.Port)
+					}
+					port, ok = resolvePort(pod, targetPort)
+				}
+			}
+		case endpoint.TargetPort != nil:
+			port, ok = resolvePort(pod, *endpoint.TargetPort)
+		}
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d doesn't match a port of the pod through service: %s",
+				m, i, svc.GetName()))
+			continue
+		}
+
+		target, w := m.target(i, endpoint, port)
+		targets = append(targets, target)
+		warnings = append(warnings, w...)
+	}
+
+	return targets, warnings
+}
+
+// Target is an endpoint of a monitor resolved to a port of a pod. An empty
+// Interval is the interval of the sidecar.
+type Target struct {
+	Port               int32
+	Path               string
+	Scheme             string
+	Interval           string
+	InsecureSkipVerify bool
+	ServerName         string
+	Namepass           []string
+	Namedrop           []string
+}
+
+// URL returns the URL the sidecar of the pod scrapes the target at.
+func (t Target) URL() string {
+	return fmt.Sprintf("%s://localhost:%d%s", t.Scheme, t.Port, t.Path)
+}
+
+// target translates the settings of endpoint i of the monitor for port.
+func (m *Monitor) target(i int, endpoint Endpoint, port int32) (Target, []string) {
+	var warnings []string
+	t := Target{
+		Port:     port,
+		Path:     endpoint.Path,
+		Scheme:   endpoint.Scheme,
+		Interval: endpoint.Interval,
+	}
+	if t.Path == "" {
+		t.Path = "/metrics"
+	}
+	if t.Scheme == "" {
+		t.Scheme = "http"
+	}
+	// Prometheus durations like 1d aren't valid telegraf durations, the
+	// sidecar interval is used instead.
+	if _, err := time.ParseDuration(t.Interval); t.Interval != "" && err != nil {
+		warnings = append(warnings, fmt.Sprintf("%s endpoint: %d interval: %s isn't supported", m, i, t.Interval))
+		t.Interval = ""
+	}
+
+	if tls := endpoint.TLSConfig; tls != nil {
+		t.InsecureSkipVerify = tls.InsecureSkipVerify
+		t.ServerName = tls.ServerName
+		if tls.CA != nil || tls.Cert != nil || tls.KeySecret != nil || tls.CAFile != "" || tls.CertFile != "" ||
+			tls.KeyFile != "" {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d TLS certificates aren't supported", m, i))
+		}
+	}
+
+	for _, relabeling := range endpoint.MetricRelabelings {
+		action := strings.ToLower(relabeling.Action)
+		if action != "keep" && action != "drop" {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d metric relabeling action: %s isn't supported",
+				m, i, relabeling.Action))
+			continue
+		}
+		if !slices.Equal(relabeling.SourceLabels, []string{"__name__"}) {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d metric relabeling is only supported for the "+
+				"__name__ label", m, i))
+			continue
+		}
+
+		globs, ok := regexToGlobs(relabeling.Regex)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d metric relabeling regex: %s can't be "+
+				"translated to a glob", m, i, relabeling.Regex))
+			continue
+		}
+		// Several keep relabelings each keep a subset of the metrics, while
+		// the namepass globs are alternatives.
+		if action == "keep" && t.Namepass != nil {
+			warnings = append(warnings, fmt.Sprintf("%s endpoint: %d only the first keep metric relabeling is "+
+				"supported", m, i))
+			continue
+		}
+		if action == "keep" {
+			t.Namepass = globs
+		} else {
+			t.Namedrop = append(t.Namedrop, globs...)
+		}
+	}
+
+	return t, warnings
+}
+
+// regexToGlobs translates a Prometheus relabeling regex matching metric names
+// into telegraf globs. Only alternatives of names with the wildcards ".*", ".+"
+// and "." can be translated.
+func regexToGlobs(regex string) ([]string, bool) {
+	if regex == "" {
+		regex = "(.*)"
+	}
+	// Prometheus regexes are anchored, and the globs match whole names.
+	regex = strings.TrimSuffix(strings.TrimPrefix(regex, "^"), "$")
+	if strings.HasPrefix(regex, "(") && strings.HasSuffix(regex, ")") &&
+		!strings.ContainsAny(regex[1:len(regex)-1], "()") {
+		regex = regex[1 : len(regex)-1]
+	}
+
+	var globs []string
+	for _, alternative := range strings.Split(regex, "|") {
+		var glob strings.Builder
+		for i := 0; i < len(alternative); i++ {
+			c := alternative[i]
+			switch {
+			case strings.HasPrefix(alternative[i:], ".*"):
+				glob.WriteString("*")
+				i++
+			case strings.HasPrefix(alternative[i:], ".+"):
+				glob.WriteString("?*")
+				i++
+			case c == '.':
+				glob.WriteByte('?')
+			case c == '\\' && i+1 < len(alternative) && isNameChar(alternative[i+1]):
+				glob.WriteByte(alternative[i+1])
+				i++
+			case isNameChar(c):
+				glob.WriteByte(c)
+			default:
+				return nil, false
+			}
+		}
+		if glob.Len() == 0 {
+			return nil, false
+		}
+		globs = append(globs, glob.String())
+	}
+
+	return globs, true
+}
+
+// isNameChar returns whether c can be part of a Prometheus metric name.
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':'
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2024 Josh Michielsen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitors
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/jmickey/telegraf-sidecar-operator/internal/metadata"
+)
+
+func newMonitor(gvk schema.GroupVersionKind, namespace, name string, spec map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func newTestSource(t *testing.T, objs ...client.Object) *Source {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	kinds := []schema.GroupVersionKind{PodMonitorGVK, ServiceMonitorGVK}
+	for _, gvk := range kinds {
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithIndex(&corev1.Service{}, ServiceSelectorIndex, indexServiceSelector).Build()
+	return newSource(c, c, c, kinds)
+}
+
+func testPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "apps",
+			Labels:    map[string]string{"app": "web", metadata.SidecarInjectedLabel: "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "web",
+				Ports: []corev1.ContainerPort{
+					{Name: "http", ContainerPort: 8080},
+					{Name: "metrics", ContainerPort: 9090},
+				},
+			}},
+		},
+	}
+}
+
+func TestSource_Targets(t *testing.T) {
+	selector := map[string]any{"matchLabels": map[string]any{"app": "web"}}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps", Labels: map[string]string{"monitored": "true"}},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "web"},
+			Ports: []corev1.ServicePort{
+				{Name: "web-metrics", Port: 80, TargetPort: intstr.FromString("metrics")},
+				{Name: "direct", Port: 8080},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		objs         []client.Object
+		want         []Target
+		wantWarnings []string
+	}{
+		{
+			name: "pod monitor endpoint resolves the container port",
+			objs: []client.Object{newMonitor(PodMonitorGVK, "apps", "web", map[string]any{
+				"selector": selector,
+				"podMetricsEndpoints": []any{map[string]any{
+					"port":     "metrics",
+					"path":     "/stats",
+					"scheme":   "https",
+					"interval": "30s",
+					"tlsConfig": map[string]any{
+						"insecureSkipVerify": true,
+						"serverName":         "web.apps",
+					},
+				}},
+			})},
+			want: []Target{{
+				Port: 9090, Path: "/stats", Scheme: "https", Interval: "30s",
+				InsecureSkipVerify: true, ServerName: "web.apps",
+			}},
+		},
+		{
+			name: "pod monitor selecting other pods is ignored",
+			objs: []client.Object{newMonitor(PodMonitorGVK, "apps", "db", map[string]any{
+				"selector":            map[string]any{"matchLabels": map[string]any{"app": "db"}},
+				"podMetricsEndpoints": []any{map[string]any{"port": "metrics"}},
+			})},
+		},
+		{
+			name: "pod monitor in another namespace only applies when selecting the namespace",
+			objs: []client.Object{
+				newMonitor(PodMonitorGVK, "monitoring", "own", map[string]any{
+					"selector":            selector,
+					"podMetricsEndpoints": []any{map[string]any{"port": "http"}},
+				}),
+				newMonitor(PodMonitorGVK, "monitoring", "any", map[string]any{
+					"selector":            selector,
+					"namespaceSelector":   map[string]any{"any": true},
+					"podMetricsEndpoints": []any{map[string]any{"port": "metrics"}},
+				}),
+			},
+			want: []Target{{Port: 9090, Path: "/metrics", Scheme: "http"}},
+		},
+		{
+			name: "service monitor resolves the target port of the service",
+			objs: []client.Object{
+				service,
+				newMonitor(ServiceMonitorGVK, "apps", "web", map[string]any{
+					"selector": map[string]any{"matchLabels": map[string]any{"monitored": "true"}},
+					"endpoints": []any{
+						map[string]any{"port": "web-metrics"},
+						map[string]any{"port": "direct"},
+					},
+				}),
+			},
+			want: []Target{
+				{Port: 9090, Path: "/metrics", Scheme: "http"},
+				{Port: 8080, Path: "/metrics", Scheme: "http"},
+			},
+		},
+		{
+			name: "unknown port and certificates are reported",
+			objs: []client.Object{newMonitor(PodMonitorGVK, "apps", "web", map[string]any{
+				"selector": selector,
+				"podMetricsEndpoints": []any{
+					map[string]any{"port": "missing"},
+					map[string]any{
+						"port":      "metrics",
+						"tlsConfig": map[string]any{"caFile": "/etc/ca.crt"},
+					},
+				},
+			})},
+			want: []Target{{Port: 9090, Path: "/metrics", Scheme: "http"}},
+			wantWarnings: []string{
+				"PodMonitor: apps/web endpoint: 0 doesn't match a port of the pod",
+				"PodMonitor: apps/web endpoint: 1 TLS certificates aren't supported",
+			},
+		},
+		{
+			name: "metric relabelings are translated to namepass and namedrop",
+			objs: []client.Object{newMonitor(PodMonitorGVK, "apps", "web", map[string]any{
+				"selector": selector,
+				"podMetricsEndpoints": []any{map[string]any{
+					"port": "metrics",
+					"metricRelabelings": []any{
+						map[string]any{"sourceLabels": []any{"__name__"}, "regex": "(http_.*|up)", "action": "keep"},
+						map[string]any{"sourceLabels": []any{"__name__"}, "regex": "http_debug_.+", "action": "drop"},
+						map[string]any{"sourceLabels": []any{"__name__"}, "regex": "go_[a-z]+", "action": "drop"},
+						map[string]any{"sourceLabels": []any{"job"}, "regex": "web", "action": "keep"},
+						map[string]any{"targetLabel": "env", "replacement": "prod", "action": "replace"},
+					},
+				}},
+			})},
+			want: []Target{{
+				Port: 9090, Path: "/metrics", Scheme: "http",
+				Namepass: []string{"http_*", "up"},
+				Namedrop: []string{"http_debug_?*"},
+			}},
+			wantWarnings: []string{
+				"PodMonitor: apps/web endpoint: 0 metric relabeling regex: go_[a-z]+ can't be translated to a glob",
+				"PodMonitor: apps/web endpoint: 0 metric relabeling is only supported for the __name__ label",
+				"PodMonitor: apps/web endpoint: 0 metric relabeling action: replace isn't supported",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSource(t, tt.objs...)
+			got, warnings, err := s.Targets(context.Background(), testPod())
+			if err != nil {
+				t.Fatalf("Targets() error = %v", err)
+			}
+			if !slices.EqualFunc(got, tt.want, equalTargets) {
+				t.Errorf("Targets() = %+v, want %+v", got, tt.want)
+			}
+			if !slices.Equal(warnings, tt.wantWarnings) {
+				t.Errorf("Targets() warnings = %q, want %q", warnings, tt.wantWarnings)
+			}
+
+			selects, err := s.Selects(context.Background(), testPod())
+			if err != nil {
+				t.Fatalf("Selects() error = %v", err)
+			}
+			if selects != (len(tt.want) > 0) {
+				t.Errorf("Selects() = %v, want %v", selects, len(tt.want) > 0)
+			}
+		})
+	}
+}
+
+func equalTargets(a, b Target) bool {
+	return a.Port == b.Port && a.Path == b.Path && a.Scheme == b.Scheme && a.Interval == b.Interval &&
+		a.InsecureSkipVerify == b.InsecureSkipVerify && a.ServerName == b.ServerName &&
+		slices.Equal(a.Namepass, b.Namepass) && slices.Equal(a.Namedrop, b.Namedrop)
+}
+
+func TestSource_Selects(t *testing.T) {
+	serviceMonitor := newMonitor(ServiceMonitorGVK, "apps", "web", map[string]any{
+		"selector": map[string]any{"matchLabels": map[string]any{"monitored": "true"}},
+	})
+	newService := func(name string, selector map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps", Labels: map[string]string{"monitored": "true"}},
+			Spec:       corev1.ServiceSpec{Selector: selector},
+		}
+	}
+
+	tests := []struct {
+		name string
+		objs []client.Object
+		want bool
+	}{
+		{
+			name: "pod monitor selecting the pod",
+			objs: []client.Object{newMonitor(PodMonitorGVK, "apps", "web", map[string]any{
+				"selector": map[string]any{"matchLabels": map[string]any{"app": "web"}},
+			})},
+			want: true,
+		},
+		{
+			name: "service monitor selecting a service of the pod",
+			objs: []client.Object{serviceMonitor, newService("web", map[string]string{"app": "web"})},
+			want: true,
+		},
+		{
+			name: "service selecting only some of the labels of the pod",
+			objs: []client.Object{serviceMonitor, newService("web", map[string]string{"app": "web", "tier": "db"})},
+		},
+		{
+			name: "service without a selector",
+			objs: []client.Object{serviceMonitor, newService("web", nil)},
+		},
+		{
+			name: "service in another namespace",
+			objs: []client.Object{serviceMonitor, &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "other", Labels: map[string]string{"monitored": "true"}},
+				Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTestSource(t, tt.objs...).Selects(context.Background(), testPod())
+			if err != nil {
+				t.Fatalf("Selects() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Selects() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSource_Pods(t *testing.T) {
+	other := testPod()
+	other.Name = "other"
+	other.Labels = map[string]string{"app": "db", metadata.SidecarInjectedLabel: "true"}
+	uninjected := testPod()
+	uninjected.Name = "uninjected"
+	delete(uninjected.Labels, metadata.SidecarInjectedLabel)
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps", Labels: map[string]string{"monitored": "true"}},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+	}
+	s := newTestSource(t, testPod(), other, uninjected, service)
+
+	tests := []struct {
+		name    string
+		monitor *unstructured.Unstructured
+		want    []types.NamespacedName
+	}{
+		{
+			name: "pod monitor",
+			monitor: newMonitor(PodMonitorGVK, "apps", "web", map[string]any{
+				"selector": map[string]any{"matchLabels": map[string]any{"app": "web"}},
+			}),
+			want: []types.NamespacedName{{Namespace: "apps", Name: "app"}},
+		},
+		{
+			name: "pod monitor selecting another namespace",
+			monitor: newMonitor(PodMonitorGVK, "monitoring", "web", map[string]any{
+				"selector":          map[string]any{"matchLabels": map[string]any{"app": "web"}},
+				"namespaceSelector": map[string]any{"matchNames": []any{"staging"}},
+			}),
+		},
+		{
+			name: "service monitor",
+			monitor: newMonitor(ServiceMonitorGVK, "apps", "web", map[string]any{
+				"selector": map[string]any{"matchLabels": map[string]any{"monitored": "true"}},
+			}),
+			want: []types.NamespacedName{{Namespace: "apps", Name: "app"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Pods(context.Background(), tt.monitor)
+			if err != nil {
+				t.Fatalf("Pods() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Pods() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexToGlobs(t *testing.T) {
+	tests := []struct {
+		regex  string
+		want   []string
+		wantOk bool
+	}{
+		{regex: "up", want: []string{"up"}, wantOk: true},
+		{regex: "", want: []string{"*"}, wantOk: true},
+		{regex: "^(http_.*|process_cpu_seconds_total)$", want: []string{"http_*", "process_cpu_seconds_total"}, wantOk: true},
+		{regex: "node_.+_bytes", want: []string{"node_?*_bytes"}, wantOk: true},
+		{regex: "go_gc_duration_seconds.", want: []string{"go_gc_duration_seconds?"}, wantOk: true},
+		{regex: `a\:b`, want: []string{"a:b"}, wantOk: true},
+		{regex: "go_[a-z]+"},
+		{regex: "(a|b)_(c|d)"},
+		{regex: "a||b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex, func(t *testing.T) {
+			got, ok := regexToGlobs(tt.regex)
+			if ok != tt.wantOk || !slices.Equal(got, tt.want) {
+				t.Errorf("regexToGlobs(%q) = %q, %v, want %q, %v", tt.regex, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	obj := newMonitor(PodMonitorGVK, "apps", "web", map[string]any{
+		"selector": map[string]any{"matchExpressions": []any{map[string]any{"key": "app", "operator": "Bogus"}}},
+	})
+	if _, err := Parse(obj); err == nil || !strings.Contains(err.Error(), "PodMonitor: apps/web") {
+		t.Errorf("Parse() error = %v, want invalid selector error", err)
+	}
+
+	obj = newMonitor(schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "Probe"}, "apps", "web", nil)
+	if _, err := Parse(obj); err == nil {
+		t.Errorf("Parse() expected an error for an unsupported kind")
+	}
+}